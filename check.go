@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/kinesis"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// check is a row of the table printed by the check command: a permission of
+// a target and the error exercising it, nil if it passed
+type check struct {
+	Target     string
+	Permission string
+	Err        error
+}
+
+// denied returns whether the check failed for lack of its permission, rather
+// than e.g. a missing queue or deployment
+func (c check) denied() bool {
+	cause := errors.Cause(c.Err)
+	if aerr, ok := cause.(awserr.Error); ok {
+		return strings.HasPrefix(aerr.Code(), "AccessDenied") || aerr.Code() == "UnauthorizedOperation"
+	}
+	return apierrors.IsForbidden(cause)
+}
+
+func newCheck(target string, permission string, f func() error) check {
+	return check{Target: target, Permission: permission, Err: f()}
+}
+
+// checkQueue exercises the AWS permissions the queue is read with. Other
+// sources are only checked to be readable.
+func checkQueue(kind string, name string, queue source.Source) []check {
+	switch q := queue.(type) {
+	case *source.SQS:
+		target := "sqs " + name
+		return []check{
+			newCheck(target, "sqs:GetQueueAttributes", func() error {
+				_, err := q.SqsClient.NumMessages()
+				return err
+			}),
+			newCheck(target, "cloudwatch:GetMetricStatistics", func() error {
+				return checkMetric(q.CloudWatchClient, "NumberOfMessagesSent")
+			}),
+		}
+	case *kinesis.KinesisClient:
+		target := "kinesis " + name
+		return []check{
+			newCheck(target, "kinesis:DescribeStreamSummary", func() error {
+				_, err := q.OpenShards()
+				return err
+			}),
+			newCheck(target, "cloudwatch:GetMetricStatistics", func() error {
+				return checkMetric(q.CloudWatch, "IncomingRecords")
+			}),
+		}
+	default:
+		return []check{
+			newCheck(kind+" "+name, "read queue", func() error {
+				_, err := queue.NumMessages()
+				return err
+			}),
+		}
+	}
+}
+
+// checkMetric reads a metric, which need not have datapoints
+func checkMetric(c *cloudwatch.CloudWatchClient, metric string) error {
+	_, err := c.GetQueueMetric(metric, "Sum")
+	if err == cloudwatch.ErrNoDatapoints {
+		return nil
+	}
+	return err
+}
+
+// checkKubernetes gets the deployment and asks the API server whether the
+// autoscaler may do everything else it does, without changing anything
+func checkKubernetes(p *scale.PodAutoScaler, persistState bool) []check {
+	target := "deployment " + p.Namespace + "/" + p.Deployment
+
+	checks := []check{
+		newCheck(target, "get deployments.apps/"+p.Deployment, func() error {
+			_, err := p.Client.AppsV1().Deployments(p.Namespace).Get(context.TODO(), p.Deployment, metav1.GetOptions{})
+			return err
+		}),
+	}
+
+	access := []authorizationv1.ResourceAttributes{
+		{Verb: "update", Group: "apps", Resource: "deployments", Name: p.Deployment},
+		{Verb: "list", Resource: "pods"},
+		{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+		{Verb: "create", Resource: "events"},
+		{Verb: "patch", Resource: "events"},
+	}
+	if persistState {
+		access = append(access,
+			authorizationv1.ResourceAttributes{Verb: "get", Resource: "configmaps", Name: p.StateConfigMap()},
+			authorizationv1.ResourceAttributes{Verb: "create", Resource: "configmaps"},
+			authorizationv1.ResourceAttributes{Verb: "update", Resource: "configmaps", Name: p.StateConfigMap()},
+		)
+	}
+
+	for _, attributes := range access {
+		attributes := attributes
+		attributes.Namespace = p.Namespace
+		checks = append(checks, newCheck(target, rbacPermission(attributes), func() error {
+			return checkAccess(p, attributes)
+		}))
+	}
+
+	return checks
+}
+
+// checkAccess returns a forbidden error if a SelfSubjectAccessReview denies
+// the access
+func checkAccess(p *scale.PodAutoScaler, attributes authorizationv1.ResourceAttributes) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attributes},
+	}
+
+	review, err := p.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to review access")
+	}
+	if !review.Status.Allowed {
+		resource := schema.GroupResource{Group: attributes.Group, Resource: attributes.Resource}
+		return apierrors.NewForbidden(resource, attributes.Name, errors.New(review.Status.Reason))
+	}
+	return nil
+}
+
+// rbacPermission formats resource attributes like kubectl auth can-i
+func rbacPermission(attributes authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource
+	if attributes.Group != "" {
+		resource += "." + attributes.Group
+	}
+	if attributes.Name != "" {
+		resource += "/" + attributes.Name
+	}
+	return attributes.Verb + " " + resource
+}
+
+// printChecks prints the checks as a table followed by the missing
+// permissions, and returns whether all passed
+func printChecks(out io.Writer, checks []check) bool {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tPERMISSION\tRESULT\tERROR")
+
+	var missing []string
+	for _, c := range checks {
+		switch {
+		case c.Err == nil:
+			fmt.Fprintf(w, "%s\t%s\tpass\t\n", c.Target, c.Permission)
+		case c.denied():
+			fmt.Fprintf(w, "%s\t%s\tdenied\t%v\n", c.Target, c.Permission, c.Err)
+			missing = append(missing, c.Permission)
+		default:
+			fmt.Fprintf(w, "%s\t%s\tfail\t%v\n", c.Target, c.Permission, c.Err)
+		}
+	}
+	w.Flush()
+
+	if len(missing) > 0 {
+		fmt.Fprintf(out, "\nMissing permissions:\n  %s\n", strings.Join(missing, "\n  "))
+	}
+
+	for _, c := range checks {
+		if c.Err != nil {
+			return false
+		}
+	}
+	return true
+}
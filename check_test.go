@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	maincloudwatch "github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+	mainsqs "github.com/hspitzlerc/kube-sqs-autoscaler/sqs"
+)
+
+func TestCheckQueue(t *testing.T) {
+	queue := &source.SQS{SqsClient: NewMockSqsClient(), CloudWatchClient: NewMockCloudWatchClient(10, 10, 0)}
+
+	checks := checkQueue("sqs", "orders", queue)
+	assert.Len(t, checks, 2)
+	for _, c := range checks {
+		assert.Nil(t, c.Err, c.Permission)
+	}
+
+	queue = &source.SQS{
+		SqsClient: &mainsqs.SqsClient{
+			Client: &MockDeniedSQS{},
+		},
+		CloudWatchClient: &maincloudwatch.CloudWatchClient{
+			Client: &MockDeniedCloudWatch{},
+		},
+	}
+
+	checks = checkQueue("sqs", "orders", queue)
+	assert.Equal(t, "sqs:GetQueueAttributes", checks[0].Permission)
+	assert.True(t, checks[0].denied())
+	assert.Equal(t, "cloudwatch:GetMetricStatistics", checks[1].Permission)
+	assert.True(t, checks[1].denied())
+}
+
+func TestCheckKubernetes(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	p.Client.(*fake.Clientset).PrependReactor("create", "selfsubjectaccessreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Namespace == "test" && attributes.Resource != "horizontalpodautoscalers"
+		return true, review, nil
+	})
+
+	checks := checkKubernetes(p, true)
+
+	var denied []string
+	for _, c := range checks {
+		if c.Err != nil {
+			assert.True(t, c.denied(), c.Permission)
+			denied = append(denied, c.Permission)
+		}
+	}
+	assert.Equal(t, []string{"list horizontalpodautoscalers.autoscaling"}, denied)
+
+	p.Deployment = "missing"
+	checks = checkKubernetes(p, true)
+	assert.NotNil(t, checks[0].Err)
+	assert.False(t, checks[0].denied())
+}
+
+func TestPrintChecks(t *testing.T) {
+	var out bytes.Buffer
+	passed := printChecks(&out, []check{
+		{Target: "sqs orders", Permission: "sqs:GetQueueAttributes"},
+		{Target: "sqs orders", Permission: "cloudwatch:GetMetricStatistics", Err: awserr.New("AccessDenied", "not authorized", nil)},
+		{Target: "deployment test/test", Permission: "get deployments.apps/test", Err: errors.New("not found")},
+	})
+
+	assert.False(t, passed)
+	assert.Contains(t, out.String(), "sqs:GetQueueAttributes          pass")
+	assert.Contains(t, out.String(), "cloudwatch:GetMetricStatistics  denied")
+	assert.Contains(t, out.String(), "get deployments.apps/test       fail")
+	assert.Contains(t, out.String(), "Missing permissions:\n  cloudwatch:GetMetricStatistics\n")
+
+	out.Reset()
+	assert.True(t, printChecks(&out, []check{{Target: "sqs orders", Permission: "sqs:GetQueueAttributes"}}))
+	assert.NotContains(t, out.String(), "Missing permissions")
+}
+
+type MockDeniedSQS struct {
+	MockSQS
+}
+
+func (m *MockDeniedSQS) GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return nil, awserr.New("AccessDenied", "Access to the resource is denied", nil)
+}
+
+type MockDeniedCloudWatch struct{}
+
+func (m *MockDeniedCloudWatch) GetMetricStatistics(*cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	return nil, awserr.New("AccessDenied", "User is not authorized to perform cloudwatch:GetMetricStatistics", nil)
+}
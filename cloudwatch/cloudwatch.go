@@ -4,12 +4,15 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 
 	"github.com/pkg/errors"
 )
 
+// ErrNoDatapoints is returned when a metric has no datapoint in the last minute
+var ErrNoDatapoints = errors.New("Failed to get queue metric datapoints")
+
 type CloudWatch interface {
 	GetMetricStatistics(*cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error)
 }
@@ -17,13 +20,20 @@ type CloudWatch interface {
 type CloudWatchClient struct {
 	Client	CloudWatch
 	Queue	string
+	// Namespace and Dimension select the metrics of Queue, AWS/SQS and
+	// QueueName if empty
+	Namespace	string
+	Dimension	string
 }
 
-func NewCloudWatchClient(queue string, region string) *CloudWatchClient {
-	svc := cloudwatch.New(session.New(), &aws.Config{Region: aws.String(region)})
+// NewCloudWatchClient creates a client for the queue from a session shared
+// with other clients. The configs set the region and optionally the endpoint,
+// e.g. of localstack.
+func NewCloudWatchClient(queue string, p client.ConfigProvider, configs ...*aws.Config) *CloudWatchClient {
+	svc := cloudwatch.New(p, configs...)
 	return &CloudWatchClient {
-		svc,
-		queue,
+		Client: svc,
+		Queue: queue,
 	}
 }
 
@@ -36,15 +46,20 @@ func int64Ptr(x int64) *int64 {
 }
 
 func (s *CloudWatchClient) GetQueueMetric(metric string, statistic string) (*cloudwatch.Datapoint, error) {
+	namespace, dimension := s.Namespace, s.Dimension
+	if namespace == "" {
+		namespace, dimension = "AWS/SQS", "QueueName"
+	}
+
 	params := &cloudwatch.GetMetricStatisticsInput {
 		Dimensions: []*cloudwatch.Dimension{
 			&cloudwatch.Dimension{
-				Name: aws.String("QueueName"),
+				Name: aws.String(dimension),
 				Value: aws.String(s.Queue),
 			},
 		},
 		MetricName: aws.String(metric),
-		Namespace: aws.String("AWS/SQS"),
+		Namespace: aws.String(namespace),
 		StartTime: timePtr(time.Now().Add(-time.Minute)),
 		EndTime: timePtr(time.Now()),
 		Period: int64Ptr(60),
@@ -57,7 +72,7 @@ func (s *CloudWatchClient) GetQueueMetric(metric string, statistic string) (*clo
 	}
 
 	if len(out.Datapoints) == 0 {
-		return &cloudwatch.Datapoint{}, errors.New("Failed to get queue metric datapoints")
+		return &cloudwatch.Datapoint{}, ErrNoDatapoints
 	}
 
 	return out.Datapoints[len(out.Datapoints) - 1], nil
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+sqs-queue-url: https://sqs.us-west-2.amazonaws.com/123456789012/orders
+max-pods: 10000000
+min-pods: 2
+scale-up-cool-down: 1m
+persist-state: true
+`), 0644)
+	assert.Nil(t, err)
+
+	config := newConfig()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.addFlags(flags)
+	flags.Parse([]string{"--min-pods=3", "--config=" + path})
+
+	errs := loadConfig(config, flags, []string{"KUBE_SQS_AUTOSCALER_MIN_PODS=4", "KUBE_SQS_AUTOSCALER_PERSIST_STATE=false", "KUBE_SQS_AUTOSCALER_SERVICE_HOST=10.0.0.1"})
+	assert.Empty(t, errs)
+	assert.Equal(t, "https://sqs.us-west-2.amazonaws.com/123456789012/orders", config.SqsQueueUrl)
+	assert.Equal(t, 10000000, config.MaxPods)
+	assert.Equal(t, 3, config.MinPods, "the command line takes precedence")
+	assert.Equal(t, time.Minute, config.ScaleUpCoolPeriod.Duration)
+	assert.False(t, config.PersistState, "environment variables take precedence over the config")
+	assert.Equal(t, 5*time.Second, config.PollInterval.Duration, "fields not in the config keep their defaults")
+
+	// misspelled and mistyped fields are rejected
+	for _, content := range []string{`{"max-podz": 10}`, `{"scale-up-cool-down": "soon"}`, `{"max-pods": "10"}`, `config: other.yaml`} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		config := newConfig()
+		config.ConfigFile = path
+		assert.Len(t, loadConfig(config, config.flags(), nil), 1, content)
+	}
+
+	config = newConfig()
+	errs = loadConfig(config, config.flags(), []string{"KUBE_SQS_AUTOSCALER_MAX_PODS=many", "KUBE_SQS_AUTOSCALER_SCALE_UP_COOL_DOWN=soon"})
+	assert.Len(t, errs, 2, errs.Error())
+
+	config.ConfigFile = filepath.Join(dir, "missing.yaml")
+	assert.Len(t, loadConfig(config, config.flags(), nil), 1)
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := newConfig()
+	config.SqsQueueUrl = "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	config.KubernetesDeploymentName = "test"
+	assert.Empty(t, config.validate(""))
+
+	config.MinPods, config.MaxPods = 6, 5
+	config.ScaleUpMessages, config.ScaleDownMessages = 10, 10
+	config.AcceptableAge = 0
+	errs := config.validate("")
+	assert.Equal(t, "--min-pods (6) must not exceed --max-pods (5)\n"+
+		"--scale-down-messages (10) must be less than --scale-up-messages (10)\n"+
+		"--acceptable-age (0) must be positive", errs.Error())
+
+	config.MinPods, config.MaxPods = 1, 5
+	config.ScaleUpMessages, config.ScaleDownMessages = 100, 10
+	config.AcceptableAge = 150
+	config.KubernetesDeploymentName = ""
+	assert.Len(t, config.validate(""), 1)
+	assert.Empty(t, config.validate("external-metrics"))
+	assert.Len(t, config.validate("scale"), 1)
+
+	config.AwsRegion = "us-west-2"
+	assert.Len(t, config.validate("external-metrics"), 1, "the region disagrees with the queue url")
+	config.AwsRegion = "us-east-1"
+
+	config.SqsExternalId = "secret"
+	assert.Equal(t, "--sqs-external-id requires --sqs-role-arn", config.validate("external-metrics").Error())
+	config.SqsRoleArn = "arn:aws:iam::222222222222:role/queue-reader"
+	assert.Empty(t, config.validate("external-metrics"))
+
+	config.QueueSource = "kinesis"
+	config.KinesisStream = "arn:aws:dynamodb:us-east-1:123456789012:table/orders/stream/2024-01-01T00:00:00.000"
+	assert.Equal(t, "--kinesis-stream must be a Kinesis data stream, DynamoDB Streams are not supported", config.validate("external-metrics").Error())
+
+	config.QueueSource = "kafka"
+	assert.Len(t, config.validate("external-metrics"), 2)
+}
@@ -0,0 +1,65 @@
+#!/bin/sh
+# Runs kube-sqs-autoscaler against ElasticMQ, a local stand-in for SQS, and
+# checks the number of visible messages it serves in external-metrics mode,
+# which needs no cluster.
+#
+# Starts ElasticMQ in docker unless ELASTICMQ_URL points to a running one.
+set -eu
+
+cd "$(dirname "$0")/.."
+
+QUEUE=kube-sqs-autoscaler-e2e
+ADDRESS=127.0.0.1:16443
+
+if [ -z "${ELASTICMQ_URL:-}" ]; then
+	ELASTICMQ_URL=http://localhost:9324
+	docker run -d --rm --name kube-sqs-autoscaler-e2e -p 9324:9324 softwaremill/elasticmq-native >/dev/null
+	trap 'docker stop kube-sqs-autoscaler-e2e >/dev/null' EXIT
+fi
+
+echo "Waiting for ElasticMQ at $ELASTICMQ_URL"
+until curl -sf -d Action=ListQueues "$ELASTICMQ_URL/" >/dev/null; do
+	sleep 1
+done
+
+QUEUE_URL=$(curl -sf -d Action=CreateQueue -d QueueName=$QUEUE "$ELASTICMQ_URL/" | sed -n 's:.*<QueueUrl>\(.*\)</QueueUrl>.*:\1:p')
+curl -sf -d Action=PurgeQueue "$QUEUE_URL" >/dev/null
+for i in 1 2 3; do
+	curl -sf -d Action=SendMessage -d MessageBody=message-$i "$QUEUE_URL" >/dev/null
+done
+
+go build -o kube-sqs-autoscaler .
+
+# the API only serves clients with a certificate signed by the requestheader
+# CA, which is presented by the aggregation layer in a cluster
+CERTS=$(mktemp -d)
+openssl req -x509 -newkey rsa:2048 -nodes -days 1 -subj /CN=e2e-requestheader-ca \
+	-keyout "$CERTS/ca.key" -out "$CERTS/ca.crt" 2>/dev/null
+openssl req -newkey rsa:2048 -nodes -subj /CN=front-proxy-client \
+	-keyout "$CERTS/client.key" -out "$CERTS/client.csr" 2>/dev/null
+openssl x509 -req -days 1 -in "$CERTS/client.csr" -CA "$CERTS/ca.crt" -CAkey "$CERTS/ca.key" -CAcreateserial \
+	-out "$CERTS/client.crt" 2>/dev/null
+
+# ElasticMQ accepts any credentials
+AWS_ACCESS_KEY_ID=e2e AWS_SECRET_ACCESS_KEY=e2e ./kube-sqs-autoscaler external-metrics \
+	--sqs-queue-url="$QUEUE_URL" \
+	--sqs-endpoint="$ELASTICMQ_URL" \
+	--aws-region=elasticmq \
+	--external-metrics-address=$ADDRESS \
+	--requestheader-client-ca-file="$CERTS/ca.crt" \
+	--listen-address=127.0.0.1:18080 &
+PID=$!
+trap 'kill $PID; rm -rf "$CERTS"; docker stop kube-sqs-autoscaler-e2e >/dev/null 2>&1 || true' EXIT
+
+METRIC_URL="https://$ADDRESS/apis/external.metrics.k8s.io/v1beta1/namespaces/default/sqs_visible_messages?labelSelector=queue%3D$QUEUE"
+for i in $(seq 30); do
+	if curl -sfk --cert "$CERTS/client.crt" --key "$CERTS/client.key" "$METRIC_URL" | grep -q '"value":"3"'; then
+		echo "PASS: 3 visible messages served for $QUEUE"
+		exit 0
+	fi
+	sleep 1
+done
+
+echo "FAIL: expected 3 visible messages, got:"
+curl -sk --cert "$CERTS/client.crt" --key "$CERTS/client.key" "$METRIC_URL"
+exit 1
@@ -0,0 +1,196 @@
+package externalmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	externalmetricsv1beta1 "k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+const (
+	groupVersion = "external.metrics.k8s.io/v1beta1"
+	prefix       = "/apis/" + groupVersion
+
+	// QueueLabel selects the queue of a metric, e.g. queue_visible_messages{queue="orders"}
+	QueueLabel = "queue"
+)
+
+// metricFuncs are the served metrics, by name
+var metricFuncs = map[string]func(q source.Source) (float64, error){
+	"queue_visible_messages": func(q source.Source) (float64, error) {
+		messages, err := q.NumMessages()
+		return float64(messages), err
+	},
+	"queue_in_flight_messages": func(q source.Source) (float64, error) {
+		messages, err := q.NumInFlight()
+		return float64(messages), err
+	},
+	"queue_oldest_message_age_seconds": func(q source.Source) (float64, error) {
+		return q.Age()
+	},
+	"queue_sent_messages_per_minute": func(q source.Source) (float64, error) {
+		return q.NumSent()
+	},
+	"queue_deleted_messages_per_minute": func(q source.Source) (float64, error) {
+		return q.NumDeleted()
+	},
+}
+
+// metricAliases are the names served before other sources than SQS were
+// supported, kept for compatibility
+var metricAliases = map[string]string{
+	"sqs_visible_messages":            "queue_visible_messages",
+	"sqs_in_flight_messages":          "queue_in_flight_messages",
+	"sqs_oldest_message_age_seconds":  "queue_oldest_message_age_seconds",
+	"sqs_sent_messages_per_minute":    "queue_sent_messages_per_minute",
+	"sqs_deleted_messages_per_minute": "queue_deleted_messages_per_minute",
+}
+
+// Server serves the metrics of queues through the external.metrics.k8s.io
+// API, so HorizontalPodAutoscalers can scale on them
+type Server struct {
+	// Queues are the served queues, by the value of their queue label
+	Queues map[string]source.Source
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	switch path := strings.TrimSuffix(r.URL.Path, "/"); {
+	case path == "/apis/external.metrics.k8s.io":
+		writeJSON(w, http.StatusOK, apiGroup())
+	case path == prefix:
+		writeJSON(w, http.StatusOK, apiResourceList())
+	case strings.HasPrefix(path, prefix+"/namespaces/"):
+		// namespaces/<namespace>/<metric>
+		parts := strings.Split(strings.TrimPrefix(path, prefix+"/"), "/")
+		if len(parts) != 3 {
+			writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+			return
+		}
+		s.serveMetric(w, r, parts[2])
+	default:
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+	}
+}
+
+func (s *Server) serveMetric(w http.ResponseWriter, r *http.Request, metricName string) {
+	metricFunc, ok := metricFuncs[metricName]
+	if alias, isAlias := metricAliases[metricName]; isAlias {
+		metricFunc, ok = metricFuncs[alias]
+	}
+	if !ok {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "unknown metric "+metricName)
+		return
+	}
+
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	names := make([]string, 0, len(s.Queues))
+	for name := range s.Queues {
+		if selector.Matches(labels.Set{QueueLabel: name}) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "no queue matches "+selector.String())
+		return
+	}
+
+	list := &externalmetricsv1beta1.ExternalMetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "ExternalMetricValueList", APIVersion: groupVersion},
+	}
+	for _, name := range names {
+		value, err := metricFunc(s.Queues[name])
+		if err != nil {
+			log.Errorf("Failed to get %s of queue %s: %v", metricName, name, err)
+			writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+			return
+		}
+
+		list.Items = append(list.Items, externalmetricsv1beta1.ExternalMetricValue{
+			MetricName:   metricName,
+			MetricLabels: map[string]string{QueueLabel: name},
+			Timestamp:    metav1.NewTime(time.Now()),
+			Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func apiGroup() *metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: groupVersion, Version: "v1beta1"}
+	return &metav1.APIGroup{
+		TypeMeta:         metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"},
+		Name:             "external.metrics.k8s.io",
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+func apiResourceList() *metav1.APIResourceList {
+	list := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: groupVersion,
+	}
+
+	names := make([]string, 0, len(metricFuncs)+len(metricAliases))
+	for name := range metricFuncs {
+		names = append(names, name)
+	}
+	for name := range metricAliases {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       name,
+			Namespaced: true,
+			Kind:       "ExternalMetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+	sort.Slice(list.APIResources, func(i, j int) bool {
+		return list.APIResources[i].Name < list.APIResources[j].Name
+	})
+
+	return list
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
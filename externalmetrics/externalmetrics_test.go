@@ -0,0 +1,122 @@
+package externalmetrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	externalmetricsv1beta1 "k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/sqs"
+)
+
+func TestDiscovery(t *testing.T) {
+	s := &Server{}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/apis/external.metrics.k8s.io/v1beta1", nil))
+	assert.Equal(t, 200, w.Code)
+
+	list := &metav1.APIResourceList{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), list))
+	assert.Equal(t, "external.metrics.k8s.io/v1beta1", list.GroupVersion)
+	assert.Len(t, list.APIResources, len(metricFuncs)+len(metricAliases))
+	assert.Equal(t, "queue_deleted_messages_per_minute", list.APIResources[0].Name)
+	assert.Equal(t, "sqs_deleted_messages_per_minute", list.APIResources[len(metricFuncs)].Name)
+}
+
+func TestGetMetric(t *testing.T) {
+	s := &Server{
+		Queues: map[string]source.Source{
+			"orders":   NewMockQueue("orders", 50),
+			"payments": NewMockQueue("payments", 5),
+		},
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/queue_visible_messages?labelSelector=queue%3Dorders", nil))
+	assert.Equal(t, 200, w.Code)
+
+	list := &externalmetricsv1beta1.ExternalMetricValueList{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), list))
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "queue_visible_messages", list.Items[0].MetricName)
+	assert.Equal(t, map[string]string{"queue": "orders"}, list.Items[0].MetricLabels)
+	assert.Equal(t, int64(50), list.Items[0].Value.Value())
+
+	// without a selector all queues are returned, and the sqs_ names are
+	// aliases
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/sqs_oldest_message_age_seconds", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), list))
+	assert.Len(t, list.Items, 2)
+	assert.Equal(t, int64(30), list.Items[1].Value.Value())
+	assert.Equal(t, "sqs_oldest_message_age_seconds", list.Items[1].MetricName)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/sqs_visible_messages?labelSelector=queue%3Dother", nil))
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/unknown", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+type MockSQS struct {
+	QueueAttributes *awssqs.GetQueueAttributesOutput
+}
+
+func (m *MockSQS) GetQueueAttributes(*awssqs.GetQueueAttributesInput) (*awssqs.GetQueueAttributesOutput, error) {
+	return m.QueueAttributes, nil
+}
+
+func (m *MockSQS) SetQueueAttributes(input *awssqs.SetQueueAttributesInput) (*awssqs.SetQueueAttributesOutput, error) {
+	m.QueueAttributes = &awssqs.GetQueueAttributesOutput{
+		Attributes: input.Attributes,
+	}
+	return &awssqs.SetQueueAttributesOutput{}, nil
+}
+
+type MockCloudWatch struct {
+	Value float64
+}
+
+func (m *MockCloudWatch) GetMetricStatistics(input *awscloudwatch.GetMetricStatisticsInput) (*awscloudwatch.GetMetricStatisticsOutput, error) {
+	return &awscloudwatch.GetMetricStatisticsOutput{
+		Datapoints: []*awscloudwatch.Datapoint{
+			&awscloudwatch.Datapoint{
+				Maximum: aws.Float64(m.Value),
+				Sum:     aws.Float64(m.Value),
+			},
+		},
+	}, nil
+}
+
+func NewMockQueue(name string, messages int) *source.SQS {
+	return &source.SQS{
+		SqsClient: &sqs.SqsClient{
+			Client: &MockSQS{
+				QueueAttributes: &awssqs.GetQueueAttributesOutput{
+					Attributes: map[string]*string{
+						"ApproximateNumberOfMessages": aws.String(strconv.Itoa(messages)),
+					},
+				},
+			},
+			QueueUrl: "example.com/" + name,
+		},
+		CloudWatchClient: &cloudwatch.CloudWatchClient{
+			Client: &MockCloudWatch{Value: 30},
+			Queue:  name,
+		},
+	}
+}
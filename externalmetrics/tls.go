@@ -0,0 +1,139 @@
+package externalmetrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kclient "k8s.io/client-go/kubernetes"
+)
+
+// The ConfigMap in which the API server publishes how aggregated APIs
+// authenticate it, in kube-system unless configured otherwise
+const authenticationConfigMap = "extension-apiserver-authentication"
+
+// ClientAuth restricts the clients of the API to the aggregation layer, which
+// presents the front proxy client certificate signed by the requestheader CA
+type ClientAuth struct {
+	CAs *x509.CertPool
+	// AllowedNames are the accepted common names of the client certificate,
+	// any if empty
+	AllowedNames []string
+}
+
+// LoadClientAuth reads the requestheader CA from caFile, or else from the
+// extension-apiserver-authentication ConfigMap in namespace, which needs the
+// extension-apiserver-authentication-reader role there
+func LoadClientAuth(client kclient.Interface, caFile string, namespace string) (*ClientAuth, error) {
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read client CA")
+		}
+		return newClientAuth(pem, nil)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), authenticationConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get ConfigMap %s/%s", namespace, authenticationConfigMap)
+	}
+
+	pem, ok := cm.Data["requestheader-client-ca-file"]
+	if !ok {
+		return nil, errors.Errorf("ConfigMap %s/%s has no requestheader-client-ca-file, is the aggregation layer enabled?", namespace, authenticationConfigMap)
+	}
+
+	var names []string
+	if value := cm.Data["requestheader-allowed-names"]; value != "" {
+		if err := json.Unmarshal([]byte(value), &names); err != nil {
+			return nil, errors.Wrap(err, "Invalid requestheader-allowed-names")
+		}
+	}
+
+	return newClientAuth([]byte(pem), names)
+}
+
+func newClientAuth(pem []byte, names []string) (*ClientAuth, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("No certificates in client CA")
+	}
+	return &ClientAuth{CAs: pool, AllowedNames: names}, nil
+}
+
+// tlsConfig requires client certificates signed by the CA and, if set, with
+// one of the allowed names
+func (a *ClientAuth) tlsConfig(config *tls.Config) {
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = a.CAs
+	if len(a.AllowedNames) == 0 {
+		return
+	}
+
+	config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, name := range a.AllowedNames {
+				if chain[0].Subject.CommonName == name {
+					return nil
+				}
+			}
+		}
+		return errors.New("Client certificate has no allowed name")
+	}
+}
+
+// ListenAndServeTLS serves the API on addr to the clients allowed by auth.
+// The aggregation layer only talks to APIServices over TLS, so without a
+// certificate a self-signed one is used, which requires insecureSkipTLSVerify
+// on the APIService.
+func (s *Server) ListenAndServeTLS(addr string, certFile string, keyFile string, auth *ClientAuth) error {
+	server := &http.Server{Addr: addr, Handler: s, TLSConfig: &tls.Config{}}
+	auth.tlsConfig(server.TLSConfig)
+
+	if certFile != "" || keyFile != "" {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	}
+
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig.Certificates = []tls.Certificate{cert}
+
+	return server.ListenAndServeTLS("", "")
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "Failed to generate private key")
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "kube-sqs-autoscaler"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "Failed to create self-signed certificate")
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
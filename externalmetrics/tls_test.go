@@ -0,0 +1,94 @@
+package externalmetrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClientAuth(t *testing.T) {
+	ca, caKey := NewMockCertificate(t, "requestheader-ca", nil, nil)
+	otherCA, otherKey := NewMockCertificate(t, "other-ca", nil, nil)
+
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: authenticationConfigMap, Namespace: "cluster-auth"},
+		Data: map[string]string{
+			"requestheader-client-ca-file": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})),
+			"requestheader-allowed-names":  `["front-proxy-client"]`,
+		},
+	})
+	auth, err := LoadClientAuth(client, "", "cluster-auth")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"front-proxy-client"}, auth.AllowedNames)
+
+	server := httptest.NewUnstartedServer(&Server{})
+	server.TLS = &tls.Config{}
+	auth.tlsConfig(server.TLS)
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+		resp, err := client.Get(server.URL + "/apis/external.metrics.k8s.io/v1beta1")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.Nil(t, get(NewMockClientCertificate(t, "front-proxy-client", ca, caKey)))
+	assert.NotNil(t, get(), "clients without a certificate are rejected")
+	assert.NotNil(t, get(NewMockClientCertificate(t, "front-proxy-client", otherCA, otherKey)), "certificates of other CAs are rejected")
+	assert.NotNil(t, get(NewMockClientCertificate(t, "system:anonymous", ca, caKey)), "names not allowed are rejected")
+
+	_, err = LoadClientAuth(client, "", "kube-system")
+	assert.NotNil(t, err, "the ConfigMap is only read from the configured namespace")
+}
+
+func NewMockCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func NewMockClientCertificate(t *testing.T, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	cert, key := NewMockCertificate(t, name, ca, caKey)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
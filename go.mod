@@ -3,11 +3,20 @@ module github.com/hspitzlerc/kube-sqs-autoscaler
 go 1.14
 
 require (
+	github.com/Shopify/sarama v1.27.2
 	github.com/aws/aws-sdk-go v1.34.4
+	github.com/golang/protobuf v1.3.3
+	github.com/gomodule/redigo v1.8.3
+	github.com/lib/pq v1.8.0
+	github.com/nats-io/nats.go v1.11.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	google.golang.org/grpc v1.29.1
 	k8s.io/api v0.18.6
 	k8s.io/apimachinery v0.18.6
 	k8s.io/client-go v0.18.6
+	k8s.io/metrics v0.18.6
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// readiness reports the autoscaler as not ready while any deployment it scales
+// has a reason not to be scaled
+type readiness struct {
+	mu      sync.Mutex
+	reasons map[string]string
+}
+
+// Set records why the deployment cannot be scaled, an empty reason marks it ready
+func (r *readiness) Set(deployment string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reasons == nil {
+		r.reasons = make(map[string]string)
+	}
+	if reason == "" {
+		delete(r.reasons, deployment)
+	} else {
+		r.reasons[deployment] = reason
+	}
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.reasons) == 0 {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	deployments := make([]string, 0, len(r.reasons))
+	for deployment := range r.reasons {
+		deployments = append(deployments, deployment)
+	}
+	sort.Strings(deployments)
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	for _, deployment := range deployments {
+		fmt.Fprintf(w, "%s: %s\n", deployment, r.reasons[deployment])
+	}
+}
+
+func healthz(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	r := &readiness{}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+
+	r.Set("test/b", "conflicting HorizontalPodAutoscalers: b")
+	r.Set("test/a", "conflicting HorizontalPodAutoscalers: a")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "test/a: conflicting HorizontalPodAutoscalers: a\ntest/b: conflicting HorizontalPodAutoscalers: b\n", w.Body.String())
+
+	r.Set("test/a", "")
+	r.Set("test/b", "")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,215 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// minSampleInterval is how long consumer info is reused, so the calls of one
+// poll read the same info
+const minSampleInterval = time.Second
+
+// requestTimeout is how long to wait for a reply of the JetStream API
+const requestTimeout = 10 * time.Second
+
+type JetStream interface {
+	ConsumerInfo(stream string, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	// NextMsg returns the first message of the stream stored at or after seq
+	// with a subject matching subject
+	NextMsg(stream string, seq uint64, subject string) (*nats.RawStreamMsg, error)
+}
+
+// client adds looking up messages by subject to a JetStream context, which
+// nats.go does not support yet
+type client struct {
+	nats.JetStreamContext
+	conn *nats.Conn
+}
+
+type msgGetRequest struct {
+	Seq           uint64 `json:"seq"`
+	NextBySubject string `json:"next_by_subj,omitempty"`
+}
+
+type msgGetResponse struct {
+	Error *struct {
+		Description string `json:"description"`
+	} `json:"error,omitempty"`
+	Message *struct {
+		Subject  string    `json:"subject"`
+		Sequence uint64    `json:"seq"`
+		Time     time.Time `json:"time"`
+	} `json:"message,omitempty"`
+}
+
+// NextMsg needs NATS server 2.7 or later, older servers ignore the subject
+// and only find a message stored at seq
+func (c *client) NextMsg(stream string, seq uint64, subject string) (*nats.RawStreamMsg, error) {
+	req, err := json.Marshal(&msgGetRequest{Seq: seq, NextBySubject: subject})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.conn.Request("$JS.API.STREAM.MSG.GET."+stream, req, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &msgGetResponse{}
+	if err := json.Unmarshal(reply.Data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, errors.New(resp.Error.Description)
+	}
+	if resp.Message == nil {
+		return nil, errors.New("No message in reply")
+	}
+
+	return &nats.RawStreamMsg{
+		Subject:  resp.Message.Subject,
+		Sequence: resp.Message.Sequence,
+		Time:     resp.Message.Time,
+	}, nil
+}
+
+// Backlog is the state of a consumer at a point in time
+type Backlog struct {
+	Time time.Time
+	Info *nats.ConsumerInfo
+	// Oldest is the time the first pending message was stored, zero without
+	// pending messages
+	Oldest time.Time
+}
+
+// JetStreamClient reads the backlog of a durable consumer of a JetStream
+// stream. Rates are computed from the change of its sequences between calls.
+type JetStreamClient struct {
+	Client   JetStream
+	Stream   string
+	Consumer string
+
+	mu    sync.Mutex
+	last  *Backlog
+	rates source.Rates
+}
+
+func NewJetStreamClient(url string, stream string, consumer string) (*JetStreamClient, error) {
+	conn, err := nats.Connect(url, nats.Name("kube-sqs-autoscaler"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to NATS")
+	}
+
+	js, err := conn.JetStream(nats.MaxWait(requestTimeout))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get JetStream context")
+	}
+
+	return &JetStreamClient{
+		Client:   &client{js, conn},
+		Stream:   stream,
+		Consumer: consumer,
+	}, nil
+}
+
+// GetBacklog reads the consumer info, or returns the last one if it was read
+// less than minSampleInterval ago
+func (j *JetStreamClient) GetBacklog() (*Backlog, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.last != nil && time.Since(j.last.Time) < minSampleInterval {
+		return j.last, nil
+	}
+
+	info, err := j.Client.ConsumerInfo(j.Stream, j.Consumer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get consumer %s of stream %s", j.Consumer, j.Stream)
+	}
+
+	backlog := &Backlog{Time: time.Now(), Info: info}
+
+	// the oldest pending message is the first not acked, or else the first
+	// not delivered, on the subject of the consumer. It may have been removed
+	// by the stream's limits, then the age is unknown.
+	if info.NumAckPending > 0 || info.NumPending > 0 {
+		seq := info.Delivered.Stream + 1
+		if info.NumAckPending > 0 {
+			seq = info.AckFloor.Stream + 1
+		}
+		subject := info.Config.FilterSubject
+		if subject == "" {
+			subject = ">"
+		}
+
+		if msg, err := j.Client.NextMsg(j.Stream, seq, subject); err == nil {
+			backlog.Oldest = msg.Time
+		}
+	}
+
+	// the consumer sequences only count messages on the subject of the
+	// consumer, and messages not yet delivered will be delivered after the
+	// delivered ones
+	j.rates.Add(source.Sample{
+		Time:     backlog.Time,
+		Produced: int64(info.Delivered.Consumer + info.NumPending),
+		Consumed: int64(info.AckFloor.Consumer),
+	})
+	j.last = backlog
+
+	return backlog, nil
+}
+
+// NumMessages returns the messages not yet delivered to the consumer
+func (j *JetStreamClient) NumMessages() (int, error) {
+	backlog, err := j.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Info.NumPending), nil
+}
+
+// NumInFlight returns the messages delivered but not yet acked
+func (j *JetStreamClient) NumInFlight() (int, error) {
+	backlog, err := j.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Info.NumAckPending, nil
+}
+
+func (j *JetStreamClient) Age() (float64, error) {
+	backlog, err := j.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+
+	if backlog.Oldest.IsZero() {
+		return 0, nil
+	}
+	return time.Since(backlog.Oldest).Seconds(), nil
+}
+
+func (j *JetStreamClient) NumSent() (float64, error) {
+	if _, err := j.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	produced, _ := j.rates.PerMinute()
+	return produced, nil
+}
+
+func (j *JetStreamClient) NumDeleted() (float64, error) {
+	if _, err := j.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	_, consumed := j.rates.PerMinute()
+	return consumed, nil
+}
@@ -0,0 +1,109 @@
+package jetstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+func TestBacklog(t *testing.T) {
+	j := NewMockJetStreamClient(&nats.ConsumerInfo{
+		Config:        nats.ConsumerConfig{FilterSubject: "orders.new"},
+		Delivered:     nats.SequencePair{Consumer: 95, Stream: 100},
+		AckFloor:      nats.SequencePair{Consumer: 90, Stream: 95},
+		NumAckPending: 5,
+		NumPending:    40,
+		NumWaiting:    0,
+	}, []MockMsg{
+		{Sequence: 96, Subject: "orders.shipped", Time: time.Now().Add(-5 * time.Minute)},
+		{Sequence: 97, Subject: "orders.new", Time: time.Now().Add(-time.Minute)},
+	})
+
+	num, err := j.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 40, num)
+
+	num, err = j.NumInFlight()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, num)
+
+	// messages on other subjects are not consumed
+	age, err := j.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 60.0, age, 2)
+}
+
+func TestNewConsumer(t *testing.T) {
+	// a consumer delivering new messages starts after the stored ones
+	j := NewMockJetStreamClient(&nats.ConsumerInfo{
+		Delivered:  nats.SequencePair{Consumer: 0, Stream: 500},
+		AckFloor:   nats.SequencePair{Consumer: 0, Stream: 500},
+		NumPending: 10,
+	}, []MockMsg{
+		{Sequence: 1, Subject: "orders.new", Time: time.Now().Add(-24 * time.Hour)},
+		{Sequence: 501, Subject: "orders.new", Time: time.Now().Add(-time.Minute)},
+	})
+
+	age, err := j.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 60.0, age, 2)
+}
+
+func TestRates(t *testing.T) {
+	j := NewMockJetStreamClient(&nats.ConsumerInfo{
+		Delivered: nats.SequencePair{Consumer: 200, Stream: 400},
+		AckFloor:  nats.SequencePair{Consumer: 200, Stream: 400},
+	}, nil)
+
+	// half a minute ago 130 messages were pending or delivered and 170 acked
+	j.rates.Add(source.Sample{Time: time.Now().Add(-30 * time.Second), Produced: 130, Consumed: 170})
+
+	sent, err := j.NumSent()
+	assert.Nil(t, err)
+	assert.InDelta(t, 140.0, sent, 1)
+
+	deleted, err := j.NumDeleted()
+	assert.Nil(t, err)
+	assert.InDelta(t, 60.0, deleted, 1)
+
+	age, err := j.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, age)
+}
+
+type MockMsg struct {
+	Sequence uint64
+	Subject  string
+	Time     time.Time
+}
+
+type MockJetStream struct {
+	Info     *nats.ConsumerInfo
+	Messages []MockMsg
+}
+
+func (m *MockJetStream) ConsumerInfo(stream string, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	return m.Info, nil
+}
+
+func (m *MockJetStream) NextMsg(stream string, seq uint64, subject string) (*nats.RawStreamMsg, error) {
+	for _, msg := range m.Messages {
+		if msg.Sequence >= seq && (subject == ">" || msg.Subject == subject) {
+			return &nats.RawStreamMsg{Sequence: msg.Sequence, Subject: msg.Subject, Time: msg.Time}, nil
+		}
+	}
+	return nil, errors.New("no message found")
+}
+
+func NewMockJetStreamClient(info *nats.ConsumerInfo, messages []MockMsg) *JetStreamClient {
+	return &JetStreamClient{
+		Client:   &MockJetStream{Info: info, Messages: messages},
+		Stream:   "ORDERS",
+		Consumer: "workers",
+	}
+}
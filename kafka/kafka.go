@@ -0,0 +1,200 @@
+package kafka
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/metrics"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// minSampleInterval is how long offsets are reused, so the calls of one poll
+// read the same offsets
+const minSampleInterval = time.Second
+
+var partitionLag = metrics.NewGauge("kube_sqs_autoscaler_kafka_partition_lag", "Number of messages the consumer group is behind on a partition", "topic", "group", "partition")
+
+type Kafka interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+type Admin interface {
+	ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error)
+}
+
+// Offsets are the offsets of a topic and consumer group at a point in time
+type Offsets struct {
+	Time time.Time
+	// Produced is the sum of the high watermarks of all partitions
+	Produced int64
+	// Committed is the sum of the offsets committed by the consumer group
+	Committed int64
+	// Lag is the number of messages the consumer group is behind, by partition
+	Lag map[int32]int64
+}
+
+// TotalLag returns the lag summed over all partitions
+func (o *Offsets) TotalLag() int64 {
+	lag := int64(0)
+	for _, l := range o.Lag {
+		lag += l
+	}
+	return lag
+}
+
+// KafkaClient reads the lag of a consumer group on a topic. Rates are computed
+// from the change of offsets between calls.
+type KafkaClient struct {
+	Client Kafka
+	Admin  Admin
+	Topic  string
+	Group  string
+
+	mu    sync.Mutex
+	last  *Offsets
+	rates source.Rates
+}
+
+func NewKafkaClient(brokers []string, version string, topic string, group string) (*KafkaClient, error) {
+	config := sarama.NewConfig()
+	v, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Kafka version")
+	}
+	config.Version = v
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to Kafka")
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to Kafka")
+	}
+
+	return &KafkaClient{
+		Client: client,
+		Admin:  admin,
+		Topic:  topic,
+		Group:  group,
+	}, nil
+}
+
+// GetOffsets reads the offsets of the topic and consumer group, or returns the
+// last ones if they were read less than minSampleInterval ago
+func (k *KafkaClient) GetOffsets() (*Offsets, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.last != nil && time.Since(k.last.Time) < minSampleInterval {
+		return k.last, nil
+	}
+
+	partitions, err := k.Client.Partitions(k.Topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get partitions of topic %s", k.Topic)
+	}
+
+	committed, err := k.Admin.ListConsumerGroupOffsets(k.Group, map[string][]int32{k.Topic: partitions})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get offsets of consumer group %s", k.Group)
+	}
+
+	offsets := &Offsets{Time: time.Now(), Lag: make(map[int32]int64, len(partitions))}
+	for _, partition := range partitions {
+		high, err := k.Client.GetOffset(k.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get offset of partition %d", partition)
+		}
+
+		// a partition the group never committed on has no lag, it starts
+		// consuming at the newest offset
+		offset := high
+		if block := committed.GetBlock(k.Topic, partition); block != nil {
+			if block.Err != sarama.ErrNoError {
+				return nil, errors.Wrapf(block.Err, "Failed to get offset of consumer group %s on partition %d", k.Group, partition)
+			}
+			if block.Offset >= 0 {
+				offset = block.Offset
+			}
+		}
+
+		lag := high - offset
+		if lag < 0 {
+			lag = 0
+		}
+
+		offsets.Produced += high
+		offsets.Committed += offset
+		offsets.Lag[partition] = lag
+		partitionLag.Set(float64(lag), k.Topic, k.Group, strconv.Itoa(int(partition)))
+	}
+
+	k.last = offsets
+	k.rates.Add(source.Sample{Time: offsets.Time, Produced: offsets.Produced, Consumed: offsets.Committed})
+
+	return offsets, nil
+}
+
+// NumMessages returns the total lag of the consumer group
+func (k *KafkaClient) NumMessages() (int, error) {
+	offsets, err := k.GetOffsets()
+	if err != nil {
+		return 0, err
+	}
+	return int(offsets.TotalLag()), nil
+}
+
+// NumInFlight is always 0, Kafka does not track messages being processed
+func (k *KafkaClient) NumInFlight() (int, error) {
+	return 0, nil
+}
+
+// Age estimates the age of the oldest unconsumed message as the time it took
+// to produce the lag
+func (k *KafkaClient) Age() (float64, error) {
+	offsets, err := k.GetOffsets()
+	if err != nil {
+		return 0, err
+	}
+
+	produced, _ := k.rates.PerMinute()
+	if produced <= 0 {
+		return 0, nil
+	}
+	return float64(offsets.TotalLag()) / produced * 60, nil
+}
+
+func (k *KafkaClient) NumSent() (float64, error) {
+	if _, err := k.GetOffsets(); err != nil {
+		return 0, err
+	}
+
+	produced, _ := k.rates.PerMinute()
+	return produced, nil
+}
+
+func (k *KafkaClient) NumDeleted() (float64, error) {
+	if _, err := k.GetOffsets(); err != nil {
+		return 0, err
+	}
+
+	_, committed := k.rates.PerMinute()
+	return committed, nil
+}
+
+// MaxConsumers returns the number of partitions, as a consumer group cannot
+// have more active consumers than partitions
+func (k *KafkaClient) MaxConsumers() (int, error) {
+	partitions, err := k.Client.Partitions(k.Topic)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to get partitions of topic %s", k.Topic)
+	}
+	return len(partitions), nil
+}
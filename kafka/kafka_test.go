@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+func TestLag(t *testing.T) {
+	k := NewMockKafkaClient(map[int32]int64{0: 100, 1: 50, 2: 10}, map[int32]int64{0: 40, 1: 50, 2: -1})
+
+	offsets, err := k.GetOffsets()
+	assert.Nil(t, err)
+	assert.Equal(t, map[int32]int64{0: 60, 1: 0, 2: 0}, offsets.Lag)
+
+	num, err := k.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 60, num)
+
+	max, err := k.MaxConsumers()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, max)
+}
+
+func TestRates(t *testing.T) {
+	k := NewMockKafkaClient(map[int32]int64{0: 100, 1: 100}, map[int32]int64{0: 100, 1: 100})
+
+	sent, err := k.NumSent()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, sent)
+
+	// offsets read 30 seconds ago, and since then 60 messages produced and
+	// 30 consumed
+	k.rates = source.Rates{}
+	k.rates.Add(source.Sample{Time: time.Now().Add(-30 * time.Second), Produced: 140, Consumed: 185})
+	k.last = nil
+
+	sent, err = k.NumSent()
+	assert.Nil(t, err)
+	assert.InDelta(t, 120.0, sent, 1)
+
+	deleted, err := k.NumDeleted()
+	assert.Nil(t, err)
+	assert.InDelta(t, 30.0, deleted, 1)
+
+	age, err := k.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, age)
+}
+
+type MockKafka struct {
+	Newest map[int32]int64
+}
+
+func (m *MockKafka) Partitions(topic string) ([]int32, error) {
+	partitions := make([]int32, 0, len(m.Newest))
+	for p := int32(0); int(p) < len(m.Newest); p++ {
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+func (m *MockKafka) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	return m.Newest[partition], nil
+}
+
+type MockAdmin struct {
+	Committed map[int32]int64
+}
+
+func (m *MockAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	resp := &sarama.OffsetFetchResponse{}
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			resp.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: m.Committed[partition], Err: sarama.ErrNoError})
+		}
+	}
+	return resp, nil
+}
+
+func NewMockKafkaClient(newest map[int32]int64, committed map[int32]int64) *KafkaClient {
+	return &KafkaClient{
+		Client: &MockKafka{Newest: newest},
+		Admin:  &MockAdmin{Committed: committed},
+		Topic:  "orders",
+		Group:  "workers",
+	}
+}
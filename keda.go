@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/externalscaler"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// Metadata of a ScaledObject delegating to the KEDA external scaler
+const (
+	kedaQueueMetadata      = "queue"
+	kedaDeploymentMetadata = "deployment"
+)
+
+// kedaScaler implements KEDA's ExternalScaler service with the same scaling
+// logic as Run. It reports the desired number of pods as the metric value with
+// a target size of 1, so KEDA's HPA scales to exactly that number.
+type kedaScaler struct {
+	Queues map[string]source.Source
+	Config *Config
+
+	// NewPodAutoScaler returns the PodAutoScaler reading the deployment's pods
+	NewPodAutoScaler func(deployment string, namespace string) *scale.PodAutoScaler
+
+	mu       sync.Mutex
+	scalers  map[string]*scale.PodAutoScaler
+	podRates map[string]float64
+}
+
+func newKedaScaler(queues map[string]source.Source, config *Config) *kedaScaler {
+	return &kedaScaler{
+		Queues: queues,
+		Config: config,
+		NewPodAutoScaler: func(deployment string, namespace string) *scale.PodAutoScaler {
+			// min and max pods are left to the ScaledObject
+			return scale.NewPodAutoScaler(deployment, namespace, math.MaxInt32, 0)
+		},
+		scalers:  make(map[string]*scale.PodAutoScaler),
+		podRates: make(map[string]float64),
+	}
+}
+
+func (k *kedaScaler) queue(ref *externalscaler.ScaledObjectRef) (string, source.Source, error) {
+	name := ref.ScalerMetadata[kedaQueueMetadata]
+	if name == "" && len(k.Queues) == 1 {
+		for name, q := range k.Queues {
+			return name, q, nil
+		}
+	}
+
+	q, ok := k.Queues[name]
+	if !ok {
+		return "", nil, errors.Errorf("Unknown queue %q in scaler metadata of %s/%s", name, ref.Namespace, ref.Name)
+	}
+	return name, q, nil
+}
+
+func (k *kedaScaler) podAutoScaler(ref *externalscaler.ScaledObjectRef) (*scale.PodAutoScaler, error) {
+	deployment := ref.ScalerMetadata[kedaDeploymentMetadata]
+	if deployment == "" {
+		return nil, errors.Errorf("Missing %q in scaler metadata of %s/%s", kedaDeploymentMetadata, ref.Namespace, ref.Name)
+	}
+
+	key := ref.Namespace + "/" + deployment
+	p, ok := k.scalers[key]
+	if !ok {
+		p = k.NewPodAutoScaler(deployment, ref.Namespace)
+		p.SetStartupLatency(k.Config.PodStartupLatency.Duration)
+		k.scalers[key] = p
+	}
+	return p, nil
+}
+
+func (k *kedaScaler) IsActive(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.IsActiveResponse, error) {
+	_, q, err := k.queue(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	numMessages, err := q.NumMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight, err := q.NumInFlight()
+	if err != nil {
+		return nil, err
+	}
+
+	return &externalscaler.IsActiveResponse{Result: numMessages+inFlight > 0}, nil
+}
+
+func (k *kedaScaler) StreamIsActive(ref *externalscaler.ScaledObjectRef, stream externalscaler.ExternalScaler_StreamIsActiveServer) error {
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-time.After(k.Config.PollInterval.Duration):
+			active, err := k.IsActive(stream.Context(), ref)
+			if err != nil {
+				log.Errorf("Failed to check if %s/%s is active: %v", ref.Namespace, ref.Name, err)
+				continue
+			}
+
+			if err := stream.Send(active); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (k *kedaScaler) GetMetricSpec(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.GetMetricSpecResponse, error) {
+	name, _, err := k.queue(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: []*externalscaler.MetricSpec{
+			{MetricName: kedaMetricName(name), TargetSize: 1},
+		},
+	}, nil
+}
+
+func (k *kedaScaler) GetMetrics(ctx context.Context, req *externalscaler.GetMetricsRequest) (*externalscaler.GetMetricsResponse, error) {
+	name, _, err := k.queue(req.ScaledObjectRef)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredPods, err := k.desiredPods(req.ScaledObjectRef)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := kedaMetricName(name)
+	if req.MetricName == kedaLegacyMetricName(name) {
+		metricName = req.MetricName
+	}
+
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{
+			{MetricName: metricName, MetricValue: int64(desiredPods)},
+		},
+	}, nil
+}
+
+// desiredPods returns the number of pods the deployment needs for the queue,
+// learning the rate per pod like Run does
+func (k *kedaScaler) desiredPods(ref *externalscaler.ScaledObjectRef) (int32, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, q, err := k.queue(ref)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := k.podAutoScaler(ref)
+	if err != nil {
+		return 0, err
+	}
+
+	status, err := p.GetPodStatus()
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get number of pods")
+	}
+
+	oldestMessage, err := q.Age()
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get oldest message age")
+	}
+
+	messagesProcessed, err := q.NumDeleted()
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get number of messages processed")
+	}
+
+	messagesIncoming, err := q.NumSent()
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get number of messages sent")
+	}
+
+	emptyReceives, reportsEmpty, err := numEmpty(q)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get number of empty receives")
+	}
+
+	numMessages, err := q.NumMessages()
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get queued messages")
+	}
+
+	key := p.Namespace + "/" + p.Deployment
+	pods := status.Spec
+	projectedMessages := projectBacklog(numMessages, messagesIncoming, messagesProcessed, p.StartupLatency())
+	messagesIncoming = k.Config.ageAdjustedIncoming(messagesIncoming, oldestMessage, projectedMessages)
+
+	ratePerPod := k.podRates[key]
+	if status.Ready > 0 {
+		ratePerPod = messagesProcessed / float64(status.Ready)
+	}
+
+	if numMessages <= k.Config.ScaleDownMessages {
+		k.podRates[key] = math.Max(k.podRates[key], ratePerPod)
+		podDecrement := scaleDownDecrement(pods, messagesIncoming, k.podRates[key])
+		if reportsEmpty {
+			podDecrement = k.Config.emptyReceiveDecrement(podDecrement, status.Ready, emptyReceives, messagesProcessed)
+		}
+		pods -= podDecrement
+	} else if projectedMessages >= k.Config.ScaleUpMessages {
+		k.podRates[key] = math.Max(k.podRates[key], ratePerPod)
+		// pods still starting are not added again
+		if status.Pending() == 0 {
+			pods += scaleUpIncrement(messagesIncoming, messagesProcessed, k.podRates[key])
+		}
+		// more pods than the queue allows consumers are useless
+		if max, ok := maxConsumers(q); ok && pods > max {
+			pods = max
+			if pods < status.Spec {
+				pods = status.Spec
+			}
+		}
+	} else {
+		k.podRates[key] = ratePerPod
+	}
+
+	if pods < 0 {
+		pods = 0
+	}
+	return pods, nil
+}
+
+func kedaMetricName(queue string) string {
+	return fmt.Sprintf("queue-%s-desired-pods", queue)
+}
+
+// kedaLegacyMetricName is the metric name reported before other sources than
+// SQS were supported. ScaledObjects created then may still ask for it.
+func kedaLegacyMetricName(queue string) string {
+	return fmt.Sprintf("sqs-%s-desired-pods", queue)
+}
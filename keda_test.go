@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/externalscaler"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+func NewMockKedaScaler(config *Config) (*kedaScaler, *source.SQS) {
+	q := &source.SQS{
+		SqsClient:        NewMockSqsClient(),
+		CloudWatchClient: NewMockCloudWatchClient(10, 10, 10),
+	}
+
+	k := newKedaScaler(map[string]source.Source{"orders": q}, config)
+	k.NewPodAutoScaler = func(deployment string, namespace string) *scale.PodAutoScaler {
+		return NewMockPodAutoScaler(deployment, namespace, 100, 0)
+	}
+	return k, q
+}
+
+func TestKedaScaler(t *testing.T) {
+	k, q := NewMockKedaScaler(newConfig())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	externalscaler.RegisterExternalScalerServer(server, k)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := externalscaler.NewExternalScalerClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ref := &externalscaler.ScaledObjectRef{
+		Name:           "workers",
+		Namespace:      "test",
+		ScalerMetadata: map[string]string{"queue": "orders", "deployment": "test"},
+	}
+
+	active, err := client.IsActive(ctx, ref)
+	assert.Nil(t, err)
+	assert.True(t, active.Result)
+
+	spec, err := client.GetMetricSpec(ctx, ref)
+	assert.Nil(t, err)
+	assert.Equal(t, "queue-orders-desired-pods", spec.MetricSpecs[0].MetricName)
+	assert.Equal(t, int64(1), spec.MetricSpecs[0].TargetSize)
+
+	// 50 messages is between the thresholds, keep the 3 pods
+	metrics, err := client.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: ref, MetricName: "queue-orders-desired-pods"})
+	assert.Nil(t, err)
+	assert.Equal(t, "queue-orders-desired-pods", metrics.MetricValues[0].MetricName)
+	assert.Equal(t, int64(3), metrics.MetricValues[0].MetricValue)
+
+	q.SqsClient.Client.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		Attributes: map[string]*string{"ApproximateNumberOfMessages": aws.String("100")},
+	})
+	// the former name is still answered
+	metrics, err = client.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: ref, MetricName: "sqs-orders-desired-pods"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sqs-orders-desired-pods", metrics.MetricValues[0].MetricName)
+	assert.Equal(t, int64(4), metrics.MetricValues[0].MetricValue)
+
+	_, err = client.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{Name: "workers", Namespace: "test", ScalerMetadata: map[string]string{"queue": "other"}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestKedaScalerMissingDeployment(t *testing.T) {
+	k, _ := NewMockKedaScaler(newConfig())
+
+	_, err := k.desiredPods(&externalscaler.ScaledObjectRef{Name: "workers", Namespace: "test"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,175 @@
+package kinesis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/pkg/errors"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+)
+
+// minSampleInterval is how long a backlog is reused, so the calls of one poll
+// read the same backlog
+const minSampleInterval = time.Second
+
+type Kinesis interface {
+	DescribeStreamSummary(*kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error)
+}
+
+// Backlog is the state of a stream at a point in time
+type Backlog struct {
+	Time time.Time
+	// Age is the iterator age of the slowest shard in seconds
+	Age float64
+	// Incoming and Processed are the records put and read per minute
+	Incoming  float64
+	Processed float64
+	// Shards is the number of open shards
+	Shards int
+}
+
+// KinesisClient reads how far the consumers of a Kinesis data stream are
+// behind, from the stream's CloudWatch metrics. DynamoDB Streams publish no
+// iterator age and are out of scope.
+type KinesisClient struct {
+	Client     Kinesis
+	CloudWatch *cloudwatch.CloudWatchClient
+	Stream     string
+
+	mu   sync.Mutex
+	last *Backlog
+}
+
+// NewKinesisClient creates a client for the stream from a session shared with
+// other clients. The configs set the region and optionally the endpoints, e.g.
+// of localstack.
+func NewKinesisClient(stream string, p client.ConfigProvider, kinesisConfig *aws.Config, cloudwatchConfig *aws.Config) *KinesisClient {
+	return &KinesisClient{
+		Client: kinesis.New(p, kinesisConfig),
+		CloudWatch: &cloudwatch.CloudWatchClient{
+			Client:    awscloudwatch.New(p, cloudwatchConfig),
+			Queue:     stream,
+			Namespace: "AWS/Kinesis",
+			Dimension: "StreamName",
+		},
+		Stream: stream,
+	}
+}
+
+// GetBacklog reads the metrics and shards of the stream, or returns the last
+// ones if they were read less than minSampleInterval ago
+func (k *KinesisClient) GetBacklog() (*Backlog, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.last != nil && time.Since(k.last.Time) < minSampleInterval {
+		return k.last, nil
+	}
+
+	age, err := k.metric("GetRecords.IteratorAgeMilliseconds", "Maximum")
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := k.metric("IncomingRecords", "Sum")
+	if err != nil {
+		return nil, err
+	}
+	processed, err := k.metric("GetRecords.Records", "Sum")
+	if err != nil {
+		return nil, err
+	}
+	shards, err := k.OpenShards()
+	if err != nil {
+		return nil, err
+	}
+
+	k.last = &Backlog{
+		Time:      time.Now(),
+		Age:       age / 1000,
+		Incoming:  incoming,
+		Processed: processed,
+		Shards:    shards,
+	}
+	return k.last, nil
+}
+
+// NumMessages estimates the records the consumers are behind as the records
+// that came in during the iterator age
+func (k *KinesisClient) NumMessages() (int, error) {
+	backlog, err := k.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Incoming * backlog.Age / 60), nil
+}
+
+// NumInFlight is always 0, Kinesis does not track records being processed
+func (k *KinesisClient) NumInFlight() (int, error) {
+	return 0, nil
+}
+
+// Age returns the iterator age of the slowest shard
+func (k *KinesisClient) Age() (float64, error) {
+	backlog, err := k.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Age, nil
+}
+
+func (k *KinesisClient) NumSent() (float64, error) {
+	backlog, err := k.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Incoming, nil
+}
+
+func (k *KinesisClient) NumDeleted() (float64, error) {
+	backlog, err := k.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Processed, nil
+}
+
+// metric returns a statistic of a stream metric. Kinesis only publishes
+// metrics of streams with activity, so a missing metric is 0.
+func (k *KinesisClient) metric(name string, statistic string) (float64, error) {
+	x, err := k.CloudWatch.GetQueueMetric(name, statistic)
+	if err == cloudwatch.ErrNoDatapoints {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if statistic == "Maximum" {
+		return aws.Float64Value(x.Maximum), nil
+	}
+	return aws.Float64Value(x.Sum), nil
+}
+
+// MaxConsumers returns the number of open shards, as each shard is read by a
+// single consumer
+func (k *KinesisClient) MaxConsumers() (int, error) {
+	backlog, err := k.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Shards, nil
+}
+
+// OpenShards describes the stream for its number of open shards
+func (k *KinesisClient) OpenShards() (int, error) {
+	out, err := k.Client.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{StreamName: aws.String(k.Stream)})
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to describe stream %s", k.Stream)
+	}
+	return int(aws.Int64Value(out.StreamDescriptionSummary.OpenShardCount)), nil
+}
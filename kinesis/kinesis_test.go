@@ -0,0 +1,121 @@
+package kinesis
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+)
+
+func TestBehind(t *testing.T) {
+	k := NewMockKinesisClient(4, map[string]float64{
+		"GetRecords.IteratorAgeMilliseconds": 30000,
+		"IncomingRecords":                    600,
+		"GetRecords.Records":                 500,
+	})
+
+	age, err := k.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, age)
+
+	num, err := k.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 300, num)
+
+	deleted, err := k.NumDeleted()
+	assert.Nil(t, err)
+	assert.Equal(t, 500.0, deleted)
+
+	max, err := k.MaxConsumers()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, max)
+
+	metrics := k.CloudWatch.Client.(*MockCloudWatch)
+	assert.Equal(t, "AWS/Kinesis", metrics.Namespace)
+	assert.Equal(t, "StreamName", metrics.Dimension)
+}
+
+func TestCaughtUp(t *testing.T) {
+	// an idle stream publishes no metrics
+	k := NewMockKinesisClient(4, map[string]float64{})
+
+	num, err := k.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, num)
+
+	age, err := k.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, age)
+}
+
+func TestBacklogIsCached(t *testing.T) {
+	k := NewMockKinesisClient(4, map[string]float64{"GetRecords.IteratorAgeMilliseconds": 30000})
+	metrics := k.CloudWatch.Client.(*MockCloudWatch)
+	shards := k.Client.(*MockKinesis)
+
+	k.NumMessages()
+	k.Age()
+	k.NumDeleted()
+	k.MaxConsumers()
+	assert.Equal(t, 3, metrics.Calls, "one poll reads each metric once")
+	assert.Equal(t, 1, shards.Calls, "one poll describes the stream once")
+}
+
+type MockKinesis struct {
+	Shards int64
+	Calls  int
+}
+
+func (m *MockKinesis) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	m.Calls++
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &kinesis.StreamDescriptionSummary{
+			StreamName:     input.StreamName,
+			OpenShardCount: aws.Int64(m.Shards),
+		},
+	}, nil
+}
+
+type MockCloudWatch struct {
+	Metrics   map[string]float64
+	Namespace string
+	Dimension string
+	Calls     int
+}
+
+func (m *MockCloudWatch) GetMetricStatistics(input *awscloudwatch.GetMetricStatisticsInput) (*awscloudwatch.GetMetricStatisticsOutput, error) {
+	m.Calls++
+	m.Namespace = *input.Namespace
+	m.Dimension = *input.Dimensions[0].Name
+
+	value, ok := m.Metrics[*input.MetricName]
+	if !ok {
+		return &awscloudwatch.GetMetricStatisticsOutput{}, nil
+	}
+
+	return &awscloudwatch.GetMetricStatisticsOutput{
+		Datapoints: []*awscloudwatch.Datapoint{
+			&awscloudwatch.Datapoint{
+				Maximum: aws.Float64(value),
+				Sum:     aws.Float64(value),
+			},
+		},
+	}, nil
+}
+
+func NewMockKinesisClient(shards int64, metrics map[string]float64) *KinesisClient {
+	return &KinesisClient{
+		Client: &MockKinesis{Shards: shards},
+		CloudWatch: &cloudwatch.CloudWatchClient{
+			Client:    &MockCloudWatch{Metrics: metrics},
+			Queue:     "events",
+			Namespace: "AWS/Kinesis",
+			Dimension: "StreamName",
+		},
+		Stream: "events",
+	}
+}
@@ -1,92 +1,292 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
-	"time"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
+	corev1 "k8s.io/api/core/v1"
+	kclient "k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/externalmetrics"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/externalscaler"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/jetstream"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/kafka"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/kinesis"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/metrics"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/postgres"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/pubsub"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/rabbitmq"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/redis"
 	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
 	"github.com/hspitzlerc/kube-sqs-autoscaler/sqs"
-	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
 )
 
 var (
-	pollInterval        time.Duration
-	scaleDownCoolPeriod time.Duration
-	scaleUpCoolPeriod   time.Duration
-	scaleUpMessages     int
-	scaleDownMessages   int
-	acceptableAge       float64
-	maxPods             int
-	minPods             int
-	awsRegion           string
-
-	sqsQueueUrl              string
-	sqsQueueName             string
-	kubernetesDeploymentName string
-	kubernetesNamespace      string
-
-	lastPodRate		float64
+	ready = &readiness{}
+
+	hpaConflicts      = metrics.NewGauge("kube_sqs_autoscaler_hpa_conflicts", "Number of HorizontalPodAutoscalers also scaling the deployment", "namespace", "deployment")
+	overrideActive    = metrics.NewGauge("kube_sqs_autoscaler_override_active", "Whether an override annotation is active on the deployment", "namespace", "deployment")
+	paused            = metrics.NewGauge("kube_sqs_autoscaler_paused", "Whether autoscaling of the deployment is paused by annotation", "namespace", "deployment")
+	minReplicas       = metrics.NewGauge("kube_sqs_autoscaler_min_replicas", "Min replicas of the deployment, including overrides", "namespace", "deployment")
+	maxReplicas       = metrics.NewGauge("kube_sqs_autoscaler_max_replicas", "Max replicas of the deployment, including overrides", "namespace", "deployment")
+	podStartupSeconds = metrics.NewGauge("kube_sqs_autoscaler_pod_startup_seconds", "Estimated time pods of the deployment take to become ready", "namespace", "deployment")
+	unschedulablePods = metrics.NewGauge("kube_sqs_autoscaler_unschedulable_pods", "Number of pods of the deployment that cannot be scheduled", "namespace", "deployment")
 )
 
-func Run(p *scale.PodAutoScaler, sqs *sqs.SqsClient, cloudwatch *cloudwatch.CloudWatchClient) {
-	lastScaleUpTime := time.Now()
-	lastScaleDownTime := time.Now()
+// Rollout policies, deciding what is scaled during a rolling update
+const (
+	rolloutIgnore           = "ignore"
+	rolloutSuspendScaleDown = "suspend-scale-down"
+	rolloutSuspendAll       = "suspend-all"
+)
+
+// roleSessionName names the sessions of assumed roles in CloudTrail
+const roleSessionName = "kube-sqs-autoscaler"
+
+// stateSaveInterval is how often the state is saved when no scaling happens
+const stateSaveInterval = time.Minute
+
+// Run scales the deployment of p on queue with config, applying changes of
+// the config sent by watch if not nil
+func Run(p *scale.PodAutoScaler, queue source.Source, config *Config, watch *configWatcher) {
+	state := loadState(p, config.PersistState)
+	lastSaveTime := time.Now()
+	scaleUpTarget := int32(0)
+	activeOverride := (*scale.Override)(nil).String()
+	overrideError := ""
+	var conflictingHPAs []string
+	var lastHPACheck time.Time
+	var rolloutStartTime time.Time
+	// warnings are emitted as events when their condition starts, not on
+	// every poll
+	clusterFull := false
+	maxPodsReached := false
+
+	// a nil channel never receives when the config is not watched
+	var reloads chan *Config
+	if watch != nil {
+		reloads = watch.Reloads
+	}
+
+	// a ticker keeps polling however often the config is reloaded
+	poll := time.NewTicker(config.PollInterval.Duration)
 
 	for {
 		select {
-		case <-time.After(pollInterval):
+		case changed := <-reloads:
+			pollInterval := config.PollInterval.Duration
+			if watch.Apply(changed) {
+				p.Min, p.Max = config.MinPods, config.MaxPods
+			}
+			if config.PollInterval.Duration != pollInterval {
+				poll.Stop()
+				poll = time.NewTicker(config.PollInterval.Duration)
+			}
+		case <-poll.C:
 			{
-				oldestMessage, err := cloudwatch.Age()
+				if time.Since(lastSaveTime) >= stateSaveInterval {
+					saveState(p, state, config.PersistState)
+					lastSaveTime = time.Now()
+				}
+
+				if time.Since(lastHPACheck) >= config.HPACheckPeriod.Duration {
+					lastHPACheck = time.Now()
+					conflictingHPAs = checkHPAs(p, conflictingHPAs, config.AllowHPACoexistence)
+				}
+				if len(conflictingHPAs) > 0 && !config.AllowHPACoexistence {
+					log.Infof("Horizontal pod autoscalers %s also scale the deployment, skipping", strings.Join(conflictingHPAs, ", "))
+					continue
+				}
+
+				status, err := p.GetPodStatus()
+				if err != nil {
+					log.Errorf("Failed to get number of pods: %v", err)
+					continue
+				}
+				if scaleUpDone(status, scaleUpTarget) {
+					scaleUpTarget = 0
+				}
+
+				oldestMessage, err := queue.Age()
 				if err != nil {
 					log.Errorf("Failed to get oldest message age: %v", err)
 					continue
 				}
 
-				messagesProcessed, err := cloudwatch.NumDeleted()
+				messagesProcessed, err := queue.NumDeleted()
 				if err != nil {
 					log.Errorf("Failed to get number of messages processed: %v", err)
 					continue
 				}
 
-				messagesIncoming, err := cloudwatch.NumSent()
+				messagesIncoming, err := queue.NumSent()
 				if err != nil {
 					log.Errorf("Failed to get number of messages sent: %v", err)
 					continue
 				}
 
-				numMessages, err := sqs.NumMessages()
+				emptyReceives, reportsEmpty, err := numEmpty(queue)
 				if err != nil {
-					log.Errorf("Failed to get SQS messages: %v", err)
+					log.Errorf("Failed to get number of empty receives: %v", err)
 					continue
 				}
 
-
-				pods, err := p.GetPods()
+				numMessages, err := queue.NumMessages()
 				if err != nil {
-					log.Errorf("Failed to get number of pods: %v", err)
+					log.Errorf("Failed to get queued messages: %v", err)
 					continue
 				}
 
-				if oldestMessage > acceptableAge {
-					messagesIncoming += float64(numMessages) / (acceptableAge / 60.0)
+				// pods still starting up are counted as capacity, only ready pods
+				// are used to measure the processing rate
+				pods := status.Spec
+
+				if status.OverrideError == nil {
+					overrideError = ""
+				} else if status.OverrideError.Error() != overrideError {
+					overrideError = status.OverrideError.Error()
+					log.Errorf("Ignoring invalid override annotations: %v", status.OverrideError)
+					p.Eventf(corev1.EventTypeWarning, "InvalidOverride", "Ignoring invalid override annotations: %v", status.OverrideError)
 				}
 
-				ratePerPod := messagesProcessed / float64(pods)
+				override := p.Override()
+				if override.String() != activeOverride {
+					activeOverride = override.String()
+					if override != nil {
+						log.Infof("Override active: %s", override)
+						p.Eventf(corev1.EventTypeNormal, "OverrideActive", "Autoscaling override active: %s", override)
+					} else {
+						log.Info("Override cleared")
+						p.Eventf(corev1.EventTypeNormal, "OverrideCleared", "Autoscaling override cleared")
+					}
+				}
+
+				minBound, maxBound := p.Bounds()
+				overrideActive.Set(boolToFloat(override != nil), p.Namespace, p.Deployment)
+				paused.Set(boolToFloat(override != nil && override.Paused), p.Namespace, p.Deployment)
+				minReplicas.Set(float64(minBound), p.Namespace, p.Deployment)
+				maxReplicas.Set(float64(maxBound), p.Namespace, p.Deployment)
+
+				if override != nil && override.Paused {
+					log.Infof("Autoscaling paused by annotation (%s), skipping", override)
+					continue
+				}
+
+				if state.LastReplicas > 0 && pods != state.LastReplicas && status.ManualScaleTime.IsZero() {
+					manager := status.ReplicasManager
+					if manager == "" {
+						manager = "unknown"
+					}
+
+					log.Warnf("Replicas were changed from %d to %d by %s, backing off", state.LastReplicas, pods, manager)
+					p.Eventf(corev1.EventTypeNormal, "ManualScaleDetected", "Replicas were changed from %d to %d by %s, backing off", state.LastReplicas, pods, manager)
+					if err := p.MarkManualScale(time.Now()); err != nil {
+						log.Errorf("Failed to mark manual scale: %v", err)
+						continue
+					}
 
-				if lastPodRate < ratePerPod {
-					lastPodRate = ratePerPod
+					state.Record(state.LastReplicas, pods, fmt.Sprintf("manual scale by %s", manager))
+					state.LastReplicas = pods
+					saveState(p, state, config.PersistState)
+					continue
 				}
 
-				if numMessages <= scaleDownMessages {
-					podDecrement := int32((messagesIncoming - (lastPodRate * float64(pods))) / lastPodRate)
-					if podDecrement < 1 {
-						podDecrement = 1
+				if !status.ManualScaleTime.IsZero() {
+					state.LastReplicas = pods
+					if config.ManualScaleBackoff.Duration <= 0 || time.Since(status.ManualScaleTime) < config.ManualScaleBackoff.Duration {
+						log.Infof("Backing off after manual scale, skipping. Remove the %s annotation to resume", scale.ManualScaleAnnotation)
+						continue
 					}
 
-					if lastScaleDownTime.Add(scaleDownCoolPeriod).After(time.Now()) {
+					if err := p.ClearManualScale(); err != nil {
+						log.Errorf("Failed to clear manual scale: %v", err)
+						continue
+					}
+					log.Info("Manual scale back off expired, resuming")
+				}
+
+				if override != nil && p.Clamp(pods) != pods {
+					if err := p.Scale(pods); err != nil {
+						log.Errorf("Failed scaling to override: %v", err)
+						continue
+					}
+
+					state.LastReplicas = p.Clamp(pods)
+					state.Record(pods, state.LastReplicas, fmt.Sprintf("override %s", override))
+					saveState(p, state, config.PersistState)
+					continue
+				}
+
+				// a stuck rollout must not disable autoscaling forever
+				rollingOut := false
+				if !status.RollingOut {
+					rolloutStartTime = time.Time{}
+				} else if rolloutStartTime.IsZero() {
+					rolloutStartTime = time.Now()
+					rollingOut = true
+				} else if time.Since(rolloutStartTime) < config.RolloutMaxWait.Duration {
+					rollingOut = true
+				} else {
+					log.Warnf("Rollout in progress for more than %s, scaling anyway", config.RolloutMaxWait.Duration)
+				}
+
+				if status.Unschedulable == 0 {
+					clusterFull = false
+				}
+				if pods < maxBound {
+					maxPodsReached = false
+				}
+
+				unschedulablePods.Set(float64(status.Unschedulable), p.Namespace, p.Deployment)
+				podStartupSeconds.Set(p.StartupLatency().Seconds(), p.Namespace, p.Deployment)
+
+				// new pods only help once they are ready, so scale up for the
+				// backlog expected by then
+				projectedMessages := projectBacklog(numMessages, messagesIncoming, messagesProcessed, p.StartupLatency())
+
+				messagesIncoming = config.ageAdjustedIncoming(messagesIncoming, oldestMessage, projectedMessages)
+
+				ratePerPod := state.PodRate
+				if status.Ready > 0 {
+					ratePerPod = messagesProcessed / float64(status.Ready)
+				}
+
+				// pods being replaced by a rollout skew the rate per pod
+				if state.PodRate < ratePerPod && !rollingOut {
+					state.PodRate = ratePerPod
+				}
+
+				if numMessages <= config.ScaleDownMessages {
+					if rollingOut && config.RolloutPolicy != rolloutIgnore {
+						log.Info("Waiting for rollout, skipping scale down")
+						continue
+					}
+
+					podDecrement := scaleDownDecrement(pods, messagesIncoming, state.PodRate)
+					if reportsEmpty {
+						podDecrement = config.emptyReceiveDecrement(podDecrement, status.Ready, emptyReceives, messagesProcessed)
+						if podDecrement == 0 {
+							log.Infof("Workers are still busy (%.0f empty receives), skipping scale down", emptyReceives)
+							continue
+						}
+					}
+
+					if state.LastScaleDownTime.Add(config.ScaleDownCoolPeriod.Duration).After(time.Now()) {
 						log.Info("Waiting for cool down, skipping scale down")
 						continue
 					}
@@ -96,27 +296,65 @@ func Run(p *scale.PodAutoScaler, sqs *sqs.SqsClient, cloudwatch *cloudwatch.Clou
 						continue
 					}
 
-					lastScaleDownTime = time.Now()
-				} else if numMessages >= scaleUpMessages {
-					podIncrement := int32(1)
-					if messagesIncoming > messagesProcessed {
-						podIncrement = int32((messagesIncoming - messagesProcessed) / lastPodRate)
-						if podIncrement < 1 {
-							podIncrement = 1
-						}
+					state.LastScaleDownTime = time.Now()
+					state.LastReplicas = p.Clamp(pods - podDecrement)
+					state.Record(pods, state.LastReplicas, fmt.Sprintf("%d messages in queue", numMessages))
+					saveState(p, state, config.PersistState)
+				} else if projectedMessages >= config.ScaleUpMessages {
+					if rollingOut && config.RolloutPolicy == rolloutSuspendAll {
+						log.Info("Waiting for rollout, skipping scale up")
+						continue
 					}
-					if lastScaleUpTime.Add(scaleUpCoolPeriod).After(time.Now()) {
+
+					podIncrement := scaleUpIncrement(messagesIncoming, messagesProcessed, state.PodRate)
+					if state.LastScaleUpTime.Add(config.ScaleUpCoolPeriod.Duration).After(time.Now()) {
 						log.Info("Waiting for cool down, skipping scale up ")
 						continue
 					}
-					if err := p.Scale(pods + podIncrement); err != nil {
+					if status.Available < scaleUpTarget && time.Since(state.LastScaleUpTime) < config.ScaleUpTimeout.Duration {
+						log.Infof("Waiting for %d pending pods, skipping scale up", status.Pending())
+						continue
+					}
+
+					desiredPods := pods + podIncrement
+					if max, ok := maxConsumers(queue); ok && desiredPods > max {
+						desiredPods = max
+						if desiredPods <= pods {
+							log.Infof("Queue is backed up but the deployment already has as many pods as the queue allows consumers (%d), skipping scale up", max)
+							continue
+						}
+					}
+					if status.Unschedulable > 0 {
+						log.Warnf("Queue is backed up but %d pods cannot be scheduled, the cluster is full", status.Unschedulable)
+						if !clusterFull {
+							clusterFull = true
+							p.Eventf(corev1.EventTypeWarning, "ClusterFull", "Queue is backed up but %d pods cannot be scheduled", status.Unschedulable)
+						}
+						desiredPods = config.capSchedulable(desiredPods, status)
+						if desiredPods <= pods {
+							log.Info("Waiting for unschedulable pods, skipping scale up")
+							continue
+						}
+					} else if pods >= maxBound {
+						log.Warnf("Queue is backed up but deployment is at max pods (%d)", maxBound)
+						if !maxPodsReached {
+							maxPodsReached = true
+							p.Eventf(corev1.EventTypeWarning, "MaxPodsReached", "Queue is backed up but deployment is at max pods (%d)", maxBound)
+						}
+					}
+
+					if err := p.Scale(desiredPods); err != nil {
 						log.Errorf("Failed scaling up: %v", err)
 						continue
 					}
 
-					lastScaleUpTime = time.Now()
-				} else {
-					lastPodRate = ratePerPod
+					state.LastScaleUpTime = time.Now()
+					scaleUpTarget = p.Clamp(desiredPods)
+					state.LastReplicas = scaleUpTarget
+					state.Record(pods, scaleUpTarget, fmt.Sprintf("%d messages in queue, %d projected", numMessages, projectedMessages))
+					saveState(p, state, config.PersistState)
+				} else if !rollingOut {
+					state.PodRate = ratePerPod
 				}
 			}
 		}
@@ -124,32 +362,468 @@ func Run(p *scale.PodAutoScaler, sqs *sqs.SqsClient, cloudwatch *cloudwatch.Clou
 
 }
 
+// checkHPAs looks for HorizontalPodAutoscalers scaling the same deployment and
+// returns them, or the previous ones if the check failed
+func checkHPAs(p *scale.PodAutoScaler, previous []string, allowHPACoexistence bool) []string {
+	hpas, err := p.ConflictingHPAs()
+	if err != nil {
+		log.Errorf("Failed to check for conflicting horizontal pod autoscalers: %v", err)
+		return previous
+	}
+
+	hpaConflicts.Set(float64(len(hpas)), p.Namespace, p.Deployment)
+
+	if len(hpas) == 0 {
+		ready.Set(p.Namespace+"/"+p.Deployment, "")
+	} else if allowHPACoexistence {
+		log.Warnf("Horizontal pod autoscalers %s also scale the deployment", strings.Join(hpas, ", "))
+	} else {
+		log.Errorf("Horizontal pod autoscalers %s also scale the deployment, refusing to scale. Set --allow-hpa-coexistence to scale anyway", strings.Join(hpas, ", "))
+		p.Eventf(corev1.EventTypeWarning, "HPAConflict", "Refusing to scale, horizontal pod autoscalers %s also scale the deployment", strings.Join(hpas, ", "))
+		ready.Set(p.Namespace+"/"+p.Deployment, "conflicting horizontal pod autoscalers: "+strings.Join(hpas, ", "))
+	}
+
+	return hpas
+}
+
+// loadState loads the state saved by a previous run. Without saved state the
+// cool downs start now, so a new autoscaler does not immediately scale.
+func loadState(p *scale.PodAutoScaler, persistState bool) *scale.State {
+	state := &scale.State{}
+	if persistState {
+		saved, err := p.LoadState()
+		if err != nil {
+			log.Errorf("Failed to load state, starting fresh: %v", err)
+		} else {
+			state = saved
+		}
+	}
+
+	if state.LastScaleUpTime.IsZero() {
+		state.LastScaleUpTime = time.Now()
+	}
+	if state.LastScaleDownTime.IsZero() {
+		state.LastScaleDownTime = time.Now()
+	}
+	if state.StartupLatency > 0 {
+		p.SetStartupLatency(state.StartupLatency)
+	}
+
+	return state
+}
+
+func saveState(p *scale.PodAutoScaler, state *scale.State, persistState bool) {
+	if !persistState {
+		return
+	}
+
+	state.StartupLatency = p.StartupLatency()
+	if err := p.SaveState(state); err != nil {
+		log.Errorf("Failed to save state: %v", err)
+	}
+}
+
+// maxConsumers returns how many pods can consume the queue, if it is limited
+func maxConsumers(queue source.Source) (int32, bool) {
+	limiter, ok := queue.(source.Limiter)
+	if !ok {
+		return 0, false
+	}
+
+	max, err := limiter.MaxConsumers()
+	if err != nil {
+		log.Errorf("Failed to get max consumers of queue: %v", err)
+		return 0, false
+	}
+	return int32(max), true
+}
+
+// numEmpty returns the rate workers find the queue empty at, and false if the
+// queue does not report it
+func numEmpty(queue source.Source) (float64, bool, error) {
+	receiver, ok := queue.(source.EmptyReceiver)
+	if !ok {
+		return 0, false, nil
+	}
+
+	emptyReceives, err := receiver.NumEmpty()
+	if errors.Cause(err) == source.ErrNoEmptyReceives {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return emptyReceives, true, nil
+}
+
+// awsConfig returns the config of an AWS client, with endpoint replacing the
+// service's endpoint if not empty, or else --aws-endpoint-url
+func (c *Config) awsConfig(endpoint string) *aws.Config {
+	config := &aws.Config{Region: aws.String(c.AwsRegion)}
+
+	if endpoint == "" {
+		endpoint = c.AwsEndpoint
+	}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if c.AwsDisableSSL {
+		config.DisableSSL = aws.Bool(true)
+	}
+	if c.AwsInsecureSkipVerify {
+		config.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	return config
+}
+
+// parseSqsQueue parses --sqs-queue or --sqs-queue-url and sets --aws-region to
+// the region of the queue if not set
+func (c *Config) parseSqsQueue() (*sqs.Queue, error) {
+	if c.SqsQueue != "" && c.SqsQueueUrl != "" {
+		return nil, errors.New("only one of --sqs-queue and --sqs-queue-url may be set")
+	}
+
+	ref := c.SqsQueue
+	if ref == "" {
+		ref = c.SqsQueueUrl
+	}
+
+	q, err := sqs.ParseQueue(ref, c.SqsQueueAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	region, err := q.CheckRegion(c.AwsRegion)
+	if err != nil {
+		return nil, err
+	}
+	c.AwsRegion = region
+
+	return q, nil
+}
+
+// awsSession returns the session shared by all AWS clients of a target. Its
+// credentials come from the web identity token if set, or else the default
+// credential chain, and are used to assume roleArn, or --aws-role-arn if
+// roleArn is empty. Assumed credentials are cached and refreshed before they
+// expire.
+func (c *Config) awsSession(roleArn string, externalId string) *session.Session {
+	sess := session.Must(session.NewSession(c.awsConfig("")))
+
+	if c.WebIdentityTokenFile != "" {
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewWebIdentityCredentials(sess, c.WebIdentityRoleArn, roleSessionName, c.WebIdentityTokenFile),
+		})
+	}
+
+	if roleArn == "" {
+		roleArn, externalId = c.AwsRoleArn, c.AwsExternalId
+	}
+	if roleArn != "" {
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, roleArn, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = roleSessionName
+				if externalId != "" {
+					p.ExternalID = aws.String(externalId)
+				}
+			}),
+		})
+	}
+
+	return sess
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ageAdjustedIncoming adds the rate needed to drain the backlog within the
+// acceptable age to the incoming messages, once messages get too old
+func (c *Config) ageAdjustedIncoming(messagesIncoming float64, oldestMessage float64, numMessages int) float64 {
+	if oldestMessage > c.AcceptableAge {
+		messagesIncoming += float64(numMessages) / (c.AcceptableAge / 60.0)
+	}
+	return messagesIncoming
+}
+
+// scaleUpIncrement returns how many pods to add to keep up with the incoming
+// messages, given the rate a pod processes messages at
+func scaleUpIncrement(messagesIncoming float64, messagesProcessed float64, podRate float64) int32 {
+	podIncrement := int32(1)
+	if messagesIncoming > messagesProcessed && podRate > 0 {
+		podIncrement = int32((messagesIncoming - messagesProcessed) / podRate)
+		if podIncrement < 1 {
+			podIncrement = 1
+		}
+	}
+	return podIncrement
+}
+
+// scaleDownDecrement returns how many pods to remove, given the messages sent
+// per minute and the messages a pod processes per minute
+func scaleDownDecrement(pods int32, messagesIncoming float64, podRate float64) int32 {
+	podDecrement := int32(1)
+	if podRate > 0 {
+		podDecrement = int32((messagesIncoming - (podRate * float64(pods))) / podRate)
+		if podDecrement < 1 {
+			podDecrement = 1
+		}
+	}
+	return podDecrement
+}
+
+// projectBacklog returns the number of messages expected in the queue after
+// latency, given the messages sent and deleted per minute. A shrinking backlog
+// is not projected so scaling up is never delayed.
+func projectBacklog(numMessages int, messagesIncoming float64, messagesProcessed float64, latency time.Duration) int {
+	growth := (messagesIncoming - messagesProcessed) * latency.Minutes()
+	if growth <= 0 {
+		return numMessages
+	}
+	return numMessages + int(growth)
+}
+
+// scaleUpDone returns whether a scale up to target no longer waits for pods,
+// because they are available or the replicas were changed since
+func scaleUpDone(status *scale.PodStatus, target int32) bool {
+	return status.Available >= target || status.Spec != target
+}
+
+// capSchedulable caps the desired number of pods at the number of schedulable
+// pods plus the allowed surplus. A negative surplus disables the cap.
+func (c *Config) capSchedulable(desiredPods int32, status *scale.PodStatus) int32 {
+	if c.SurplusPods < 0 {
+		return desiredPods
+	}
+
+	if max := status.Schedulable() + int32(c.SurplusPods); desiredPods > max {
+		return max
+	}
+	return desiredPods
+}
+
+// emptyReceiveDecrement adjusts a scale down decrement using the number of empty
+// receives per pod. Workers that rarely poll an empty queue are still busy, so
+// it returns 0 to refuse the scale down; workers that mostly poll an empty queue
+// are overprovisioned, so the decrement is doubled.
+func (c *Config) emptyReceiveDecrement(podDecrement int32, pods int32, emptyReceives float64, messagesProcessed float64) int32 {
+	if pods <= 0 {
+		return podDecrement
+	}
+
+	if emptyReceives/float64(pods) < c.MinEmptyReceives {
+		return 0
+	}
+
+	if emptyReceives >= c.EmptyReceiveRatio*messagesProcessed {
+		return podDecrement * 2
+	}
+
+	return podDecrement
+}
+
 func main() {
-	flag.DurationVar(&pollInterval, "poll-period", 5*time.Second, "The interval in seconds for checking if scaling is required")
-	flag.DurationVar(&scaleDownCoolPeriod, "scale-down-cool-down", 30*time.Second, "The cool down period for scaling down")
-	flag.DurationVar(&scaleUpCoolPeriod, "scale-up-cool-down", 10*time.Second, "The cool down period for scaling up")
-	flag.Float64Var(&acceptableAge, "acceptable-age", 150, "Maximum age of messages that can sit in the queue without trigging more aggressive scaling logic, in seconds")
-	flag.IntVar(&scaleUpMessages, "scale-up-messages", 100, "Number of sqs messages queued up required for scaling up")
-	flag.IntVar(&scaleDownMessages, "scale-down-messages", 10, "Number of sqs messages queued up required to scale down")
-	flag.IntVar(&maxPods, "max-pods", 5, "Max pods that kube-sqs-autoscaler can scale")
-	flag.IntVar(&minPods, "min-pods", 1, "Min pods that kube-sqs-autoscaler can scale")
-	flag.StringVar(&awsRegion, "aws-region", "", "Your AWS region")
-
-	flag.StringVar(&sqsQueueUrl, "sqs-queue-url", "", "The sqs queue url")
-	flag.StringVar(&kubernetesDeploymentName, "kubernetes-deployment", "", "Kubernetes Deployment to scale. This field is required")
-	flag.StringVar(&kubernetesNamespace, "kubernetes-namespace", "default", "The namespace your deployment is running in")
+	// the first argument may select a command other than autoscaling
+	command := ""
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
+	config := newConfig()
+	config.addFlags(flag.CommandLine)
 	flag.Parse()
 
-	sqsQueueComponents := strings.Split(sqsQueueUrl, "/")
-	sqsQueueName = sqsQueueComponents[len(sqsQueueComponents) - 1]
+	pinned := pinnedFlags(flag.CommandLine, os.Environ())
+	errs := loadConfig(config, flag.CommandLine, os.Environ())
+	if len(errs) == 0 {
+		errs = config.validate(command)
+	}
+	if len(errs) > 0 {
+		printConfigErrors(errs)
+		os.Exit(1)
+	}
+
+	if command == "validate" {
+		fmt.Println("Configuration is valid")
+		return
+	}
+
+	// only the autoscaler reads the thresholds from a single goroutine, where
+	// they can be changed between polls
+	var configWatch *configWatcher
+	if command == "" && config.ConfigFile != "" && config.ConfigReloadPeriod.Duration > 0 {
+		watcher, err := newConfigWatcher(config, command, pinned)
+		if err != nil {
+			log.Fatalf("Failed to watch config: %v", err)
+		}
+		configWatch = watcher
+		go configWatch.Watch(nil)
+	}
+
+	// manifests only need the flags, not a connection to the queue
+	if command == "manifests" {
+		if err := printManifests(os.Stdout, config); err != nil {
+			log.Fatalf("Failed to generate manifests: %v", err)
+		}
+		return
+	}
+
+	var queue source.Source
+	var queueName string
+	// the lookup of the url of a queue given by name or ARN, with the check
+	// command
+	var urlCheck *check
+	switch config.QueueSource {
+	case "sqs":
+		q, err := config.parseSqsQueue()
+		if err != nil {
+			log.Fatalf("Invalid SQS queue: %v", err)
+		}
+
+		sess := config.awsSession(config.SqsRoleArn, config.SqsExternalId)
+		if command == "check" && q.Url == "" {
+			// a denied lookup is reported in the table of checks
+			c := newCheck("sqs "+q.Name, "sqs:GetQueueUrl", func() error {
+				return q.ResolveUrl(sess, config.awsConfig(config.SqsEndpoint))
+			})
+			urlCheck = &c
+		} else if err := q.ResolveUrl(sess, config.awsConfig(config.SqsEndpoint)); err != nil {
+			log.Fatalf("Failed to resolve SQS queue: %v", err)
+		}
+
+		queueName = q.Name
+		queue = source.NewSQS(q.Url, q.Name, sess, config.awsConfig(config.SqsEndpoint), config.awsConfig(config.CloudwatchEndpoint))
+	case "rabbitmq":
+		queueName = config.RabbitmqQueue
+		queue = rabbitmq.NewRabbitMQClient(config.RabbitmqUrl, config.RabbitmqVhost, config.RabbitmqQueue)
+	case "kafka":
+		queueName = config.KafkaTopic
+		client, err := kafka.NewKafkaClient(strings.Split(config.KafkaBrokers, ","), config.KafkaVersion, config.KafkaTopic, config.KafkaGroup)
+		if err != nil {
+			log.Fatalf("Failed to create Kafka client: %v", err)
+		}
+		queue = client
+	case "redis":
+		queueName = config.RedisKey
+		queue = redis.NewRedisClient(config.RedisUrl, config.RedisKey, config.RedisGroup)
+	case "postgres":
+		var args []interface{}
+		if config.PostgresQueryArgs != "" {
+			for _, arg := range strings.Split(config.PostgresQueryArgs, ",") {
+				args = append(args, arg)
+			}
+		}
+
+		queueName = "postgres"
+		client, err := postgres.NewPostgresClient(os.Getenv(config.PostgresDsnEnv), config.PostgresQuery, args, config.PostgresQueryTimeout.Duration)
+		if err != nil {
+			log.Fatalf("Failed to create Postgres client: %v", err)
+		}
+		queue = client
+	case "pubsub":
+		queueName = config.PubsubSubscription
+		client, err := pubsub.NewPubSubClient(config.PubsubProject, config.PubsubSubscription)
+		if err != nil {
+			log.Fatalf("Failed to create Pub/Sub client: %v", err)
+		}
+		queue = client
+	case "kinesis":
+		queueName = config.KinesisStream
+		queue = kinesis.NewKinesisClient(config.KinesisStream, config.awsSession(config.KinesisRoleArn, config.KinesisExternalId), config.awsConfig(config.KinesisEndpoint), config.awsConfig(config.CloudwatchEndpoint))
+	case "jetstream":
+		queueName = config.NatsConsumer
+		client, err := jetstream.NewJetStreamClient(config.NatsUrl, config.NatsStream, config.NatsConsumer)
+		if err != nil {
+			log.Fatalf("Failed to create JetStream client: %v", err)
+		}
+		queue = client
+	default:
+		log.Fatalf("Invalid --source %q, must be sqs, rabbitmq, kafka, redis, postgres, pubsub, kinesis or jetstream", config.QueueSource)
+	}
 
-	lastPodRate = -1
+	// check may run next to the autoscaler, e.g. with kubectl exec, so it
+	// does not serve metrics on the same address
+	if command != "check" {
+		http.Handle("/metrics", metrics.Handler())
+		http.Handle("/readyz", ready)
+		http.HandleFunc("/healthz", healthz)
+		go func() {
+			log.Fatal(http.ListenAndServe(config.ListenAddress, nil))
+		}()
+	}
 
-	p := scale.NewPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, maxPods, minPods)
-	sqs := sqs.NewSqsClient(sqsQueueUrl, awsRegion)
-	cloudwatch := cloudwatch.NewCloudWatchClient(sqsQueueName, awsRegion)
+	switch command {
+	case "":
+		p := scale.NewPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
+		p.SetStartupLatency(config.PodStartupLatency.Duration)
 
-	log.Info("Starting kube-sqs-autoscaler")
-	Run(p, sqs, cloudwatch)
+		log.Info("Starting kube-sqs-autoscaler")
+		Run(p, queue, config, configWatch)
+	case "external-metrics":
+		server := &externalmetrics.Server{
+			Queues: map[string]source.Source{queueName: queue},
+		}
+
+		var client kclient.Interface
+		if config.ClientCAFile == "" {
+			restConfig, err := restclient.InClusterConfig()
+			if err != nil {
+				log.Fatalf("Failed to configure incluster config: %v", err)
+			}
+			client = kclient.NewForConfigOrDie(restConfig)
+		}
+		auth, err := externalmetrics.LoadClientAuth(client, config.ClientCAFile, config.AuthNamespace)
+		if err != nil {
+			log.Fatalf("Failed to load client CA: %v", err)
+		}
+
+		log.Infof("Serving external metrics API on %s", config.ExternalMetricsAddress)
+		log.Fatal(server.ListenAndServeTLS(config.ExternalMetricsAddress, config.TLSCertFile, config.TLSKeyFile, auth))
+	case "keda-external-scaler":
+		listener, err := net.Listen("tcp", config.KedaAddress)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", config.KedaAddress, err)
+		}
+
+		server := grpc.NewServer()
+		externalscaler.RegisterExternalScalerServer(server, newKedaScaler(map[string]source.Source{queueName: queue}, config))
+
+		log.Infof("Serving KEDA external scaler on %s", config.KedaAddress)
+		log.Fatal(server.Serve(listener))
+	case "check":
+		var checks []check
+		if urlCheck != nil {
+			checks = append(checks, *urlCheck)
+		}
+		// the queue cannot be read without its url
+		if urlCheck == nil || urlCheck.Err == nil {
+			checks = append(checks, checkQueue(config.QueueSource, queueName, queue)...)
+		}
+		if config.KubernetesDeploymentName != "" {
+			// outside a cluster the deployment cannot be checked
+			p, err := scale.NewInClusterPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
+			if err != nil {
+				target := "deployment " + config.KubernetesNamespace + "/" + config.KubernetesDeploymentName
+				checks = append(checks, check{Target: target, Permission: "kubernetes client", Err: err})
+			} else {
+				checks = append(checks, checkKubernetes(p, config.PersistState)...)
+			}
+		}
+
+		if !printChecks(os.Stdout, checks) {
+			os.Exit(1)
+		}
+	default:
+		log.Fatalf("Unknown command %q", command)
+	}
 }
@@ -1,41 +1,63 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
-	"k8s.io/api"
-	extensions "k8s.io/api/extensions/v1beta1"
-	kclient "k8s.io/client-go/kubernetes"
-	"k8s.io/apimachinery/pkg/watch"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 
+	maincloudwatch "github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/kafka"
 	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
 	mainsqs "github.com/hspitzlerc/kube-sqs-autoscaler/sqs"
 )
 
 func TestRunReachMinReplicas(t *testing.T) {
-	// override default vars for testing
-	pollInterval = 1 * time.Second
-	scaleDownCoolPeriod = 1 * time.Second
-	scaleUpCoolPeriod = 1 * time.Second
-	scaleUpMessages = 100
-	scaleDownMessages = 10
-	maxPods = 5
-	minPods = 1
-	awsRegion = "us-east-1"
-
-	sqsQueueUrl = "example.com"
-	kubernetesDeploymentName = "test"
-	kubernetesNamespace = "test"
-
-	p := NewMockPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, maxPods, minPods)
+	// override the default config for testing
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.MinEmptyReceives = 1
+	config.EmptyReceiveRatio = 5
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.AwsRegion = "us-east-1"
+
+	config.SqsQueueUrl = "example.com"
+	config.KubernetesDeploymentName = "test"
+	config.KubernetesNamespace = "test"
+
+	p := NewMockPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
 	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 100)
 
-	go Run(p, s)
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
 
 	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("10")}
 	input := &sqs.SetQueueAttributesInput{
@@ -44,29 +66,37 @@ func TestRunReachMinReplicas(t *testing.T) {
 	s.Client.SetQueueAttributes(input)
 
 	time.Sleep(10 * time.Second)
-	deployment, _ := p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(minPods), deployment.Spec.Replicas, "Number of replicas should be the min")
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(config.MinPods), *deployment.Spec.Replicas, "Number of replicas should be the min")
 }
 
 func TestRunReachMaxReplicas(t *testing.T) {
-	// override default vars for testing
-	pollInterval = 1 * time.Second
-	scaleDownCoolPeriod = 1 * time.Second
-	scaleUpCoolPeriod = 1 * time.Second
-	scaleUpMessages = 100
-	scaleDownMessages = 10
-	maxPods = 5
-	minPods = 1
-	awsRegion = "us-east-1"
-
-	sqsQueueUrl = "example.com"
-	kubernetesDeploymentName = "test"
-	kubernetesNamespace = "test"
-
-	p := NewMockPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, maxPods, minPods)
+	// override the default config for testing
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.MinEmptyReceives = 1
+	config.EmptyReceiveRatio = 5
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.AwsRegion = "us-east-1"
+
+	config.SqsQueueUrl = "example.com"
+	config.KubernetesDeploymentName = "test"
+	config.KubernetesNamespace = "test"
+
+	p := NewMockPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
 	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 100)
 
-	go Run(p, s)
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
 
 	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
 
@@ -76,27 +106,77 @@ func TestRunReachMaxReplicas(t *testing.T) {
 	s.Client.SetQueueAttributes(input)
 
 	time.Sleep(10 * time.Second)
-	deployment, _ := p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(maxPods), deployment.Spec.Replicas, "Number of replicas should be the max")
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(config.MaxPods), *deployment.Spec.Replicas, "Number of replicas should be the max")
+}
+
+func TestRunReloadKeepsPolling(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.PersistState = false
+	config.SqsQueueUrl = "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	config.KubernetesDeploymentName = "test"
+
+	watch := &configWatcher{
+		Config:     config,
+		Reloads:    make(chan *Config),
+		generation: 1,
+		initial:    newConfig(),
+	}
+	// the same config is reloaded more often than polled
+	unchanged := *config
+	go func() {
+		for {
+			changed := unchanged
+			watch.Reloads <- &changed
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 100)
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, watch)
+
+	s.Client.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		Attributes: map[string]*string{"ApproximateNumberOfMessages": aws.String("100")},
+	})
+
+	time.Sleep(5 * time.Second)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(config.MaxPods), *deployment.Spec.Replicas, "Reloads should not delay polls")
 }
 
 func TestRunScaleUpCoolDown(t *testing.T) {
-	pollInterval = 5 * time.Second
-	scaleDownCoolPeriod = 10 * time.Second
-	scaleUpCoolPeriod = 10 * time.Second
-	scaleUpMessages = 100
-	scaleDownMessages = 10
-	maxPods = 5
-	minPods = 1
-	awsRegion = "us-east-1"
-
-	sqsQueueUrl = "example.com"
-	kubernetesDeploymentName = "test"
-
-	p := NewMockPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, maxPods, minPods)
+	config := newConfig()
+	config.PollInterval.Duration = 5 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 10 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 10 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.MinEmptyReceives = 1
+	config.EmptyReceiveRatio = 5
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.AwsRegion = "us-east-1"
+
+	config.SqsQueueUrl = "example.com"
+	config.KubernetesDeploymentName = "test"
+	config.KubernetesNamespace = "test"
+
+	p := NewMockPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
 	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
 
-	go Run(p, s)
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
 
 	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
 
@@ -106,28 +186,36 @@ func TestRunScaleUpCoolDown(t *testing.T) {
 	s.Client.SetQueueAttributes(input)
 
 	time.Sleep(15 * time.Second)
-	deployment, _ := p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(4), deployment.Spec.Replicas, "Number of replicas should be 4 if cool down for scaling up was obeyed")
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas, "Number of replicas should be 4 if cool down for scaling up was obeyed")
 }
 
 func TestRunScaleDownCoolDown(t *testing.T) {
-	pollInterval = 5 * time.Second
-	scaleDownCoolPeriod = 10 * time.Second
-	scaleUpCoolPeriod = 10 * time.Second
-	scaleUpMessages = 100
-	scaleDownMessages = 10
-	maxPods = 5
-	minPods = 1
-	awsRegion = "us-east-1"
-
-	sqsQueueUrl = "example.com"
-	kubernetesDeploymentName = "test"
-	kubernetesNamespace = "test"
-
-	p := NewMockPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, maxPods, minPods)
+	config := newConfig()
+	config.PollInterval.Duration = 5 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 10 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 10 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.MinEmptyReceives = 1
+	config.EmptyReceiveRatio = 5
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.AwsRegion = "us-east-1"
+
+	config.SqsQueueUrl = "example.com"
+	config.KubernetesDeploymentName = "test"
+	config.KubernetesNamespace = "test"
+
+	p := NewMockPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
 	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
 
-	go Run(p, s)
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
 
 	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("10")}
 
@@ -137,70 +225,516 @@ func TestRunScaleDownCoolDown(t *testing.T) {
 	s.Client.SetQueueAttributes(input)
 
 	time.Sleep(15 * time.Second)
-	deployment, _ := p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(2), deployment.Spec.Replicas, "Number of replicas should be 2 if cool down for scaling down was obeyed")
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas, "Number of replicas should be 2 if cool down for scaling down was obeyed")
 }
 
-type MockDeployment struct {
-	client *MockKubeClient
-}
+func TestRunWaitForPendingPods(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Minute
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.AwsRegion = "us-east-1"
+
+	config.SqsQueueUrl = "example.com"
+	config.KubernetesDeploymentName = "test"
+	config.KubernetesNamespace = "test"
+
+	p := NewMockPodAutoScaler(config.KubernetesDeploymentName, config.KubernetesNamespace, config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
+
+	// new pods never become available
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	p.Client = fake.NewSimpleClientset(deployment)
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
+
+	input := &sqs.SetQueueAttributesInput{
+		Attributes: Attributes,
+	}
+	s.Client.SetQueueAttributes(input)
 
-type MockKubeClient struct {
-	// stores the state of Deployment as if the api server did
-	Deployment *extensions.Deployment
+	time.Sleep(6 * time.Second)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas, "Number of replicas should be 4 if pending pods were waited for")
 }
 
-func (m *MockDeployment) Get(name string) (*extensions.Deployment, error) {
-	return m.client.Deployment, nil
+func TestLoadState(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	lastScaleUpTime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	// without saved state the cool downs start now
+	state := loadState(p, true)
+	assert.Equal(t, 0.0, state.PodRate)
+	assert.WithinDuration(t, time.Now(), state.LastScaleUpTime, time.Second)
+
+	p.SetStartupLatency(90 * time.Second)
+	state.PodRate = 12.5
+	state.LastScaleUpTime = lastScaleUpTime
+	saveState(p, state, true)
+
+	restarted := NewMockPodAutoScaler("test", "test", 5, 1)
+	restarted.Client = p.Client
+	state = loadState(restarted, true)
+
+	assert.Equal(t, 12.5, state.PodRate)
+	assert.True(t, lastScaleUpTime.Equal(state.LastScaleUpTime))
+	assert.Equal(t, 90*time.Second, restarted.StartupLatency())
 }
 
-func (m *MockDeployment) Update(deployment *extensions.Deployment) (*extensions.Deployment, error) {
-	m.client.Deployment.Spec.Replicas = deployment.Spec.Replicas
-	return m.client.Deployment, nil
+func TestProjectBacklog(t *testing.T) {
+	assert.Equal(t, 100, projectBacklog(100, 50, 20, 0))
+	assert.Equal(t, 145, projectBacklog(100, 50, 20, 90*time.Second))
+	assert.Equal(t, 100, projectBacklog(100, 20, 50, 90*time.Second))
 }
 
-func (m *MockDeployment) List(opts api.ListOptions) (*extensions.DeploymentList, error) {
-	return nil, nil
+func TestCapSchedulable(t *testing.T) {
+	config := newConfig()
+	status := &scale.PodStatus{Spec: 5, Unschedulable: 2}
+
+	config.SurplusPods = -1
+	assert.Equal(t, int32(8), config.capSchedulable(8, status))
+
+	config.SurplusPods = 1
+	assert.Equal(t, int32(4), config.capSchedulable(8, status))
+	assert.Equal(t, int32(4), config.capSchedulable(4, status))
 }
 
-func (m *MockDeployment) Delete(name string, options *api.DeleteOptions) error {
-	return nil
+func TestScaleUpDone(t *testing.T) {
+	assert.False(t, scaleUpDone(&scale.PodStatus{Spec: 5, Available: 3}, 5), "pods are still pending")
+	assert.True(t, scaleUpDone(&scale.PodStatus{Spec: 5, Available: 5}, 5))
+	assert.True(t, scaleUpDone(&scale.PodStatus{Spec: 2, Available: 2}, 5), "replicas were scaled down since")
+	assert.True(t, scaleUpDone(&scale.PodStatus{Spec: 7, Available: 3}, 5), "replicas were scaled up since")
 }
 
-func (m *MockDeployment) Create(*extensions.Deployment) (*extensions.Deployment, error) {
-	return nil, nil
+func TestRunPaused(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
+
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	deployment.Annotations = map[string]string{scale.PausedAnnotation: "true"}
+	p.Client.AppsV1().Deployments("test").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
+
+	input := &sqs.SetQueueAttributesInput{
+		Attributes: Attributes,
+	}
+	s.Client.SetQueueAttributes(input)
+
+	time.Sleep(4 * time.Second)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas, "Number of replicas should not change while paused")
 }
 
-func (m *MockDeployment) UpdateStatus(*extensions.Deployment) (*extensions.Deployment, error) {
-	return nil, nil
+func TestRunInvalidOverrideOnce(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	recorder := record.NewFakeRecorder(100)
+	p.Recorder = recorder
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
+
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	deployment.Annotations = map[string]string{scale.PausedAnnotation: "maybe"}
+	p.Client.AppsV1().Deployments("test").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	time.Sleep(4 * time.Second)
+
+	invalid := 0
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, "InvalidOverride") {
+			invalid++
+		}
+	}
+	assert.Equal(t, 1, invalid, "InvalidOverride should only be emitted when the error changes")
 }
 
-func (m *MockDeployment) Watch(opts api.ListOptions) (watch.Interface, error) {
-	return nil, nil
+func TestRunManualScale(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ManualScaleBackoff.Duration = 1 * time.Minute
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
+
+	input := &sqs.SetQueueAttributesInput{
+		Attributes: Attributes,
+	}
+	s.Client.SetQueueAttributes(input)
+
+	time.Sleep(2500 * time.Millisecond)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.True(t, *deployment.Spec.Replicas > 3, "Number of replicas should have been scaled up")
+
+	deployment.Spec.Replicas = int32Ptr(2)
+	p.Client.AppsV1().Deployments("test").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+
+	time.Sleep(3 * time.Second)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas, "Number of replicas should be 2 while backing off after a manual scale")
+	assert.Contains(t, deployment.Annotations, scale.ManualScaleAnnotation)
 }
 
-func (m *MockDeployment) Rollback(*extensions.DeploymentRollback) error {
-	return nil
+func TestCheckHPAs(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	p.Client = fake.NewSimpleClientset(deployment, &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-hpa", Namespace: "test"},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "test"},
+		},
+	})
+
+	assert.Equal(t, []string{"test-hpa"}, checkHPAs(p, nil, false))
+
+	w := httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+
+	p.Client = fake.NewSimpleClientset(deployment)
+	assert.Empty(t, checkHPAs(p, []string{"test-hpa"}, false))
+
+	w = httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
 }
 
-func (m *MockKubeClient) Deployments(namespace string) kclient.DeploymentInterface {
-	return &MockDeployment{
-		client: m,
+func TestRunRolloutSuspendAll(t *testing.T) {
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+	config.RolloutPolicy = rolloutSuspendAll
+	config.RolloutMaxWait.Duration = 1 * time.Minute
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 10)
+
+	// one of three pods was updated so far
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	deployment.Status.UpdatedReplicas = 1
+	p.Client = fake.NewSimpleClientset(deployment)
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("100")}
+
+	input := &sqs.SetQueueAttributesInput{
+		Attributes: Attributes,
 	}
+	s.Client.SetQueueAttributes(input)
+
+	time.Sleep(4 * time.Second)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas, "Number of replicas should not change during a rollout")
 }
 
-func NewMockKubeClient() *MockKubeClient {
-	return &MockKubeClient{
-		Deployment: &extensions.Deployment{
-			Spec: extensions.DeploymentSpec{
-				Replicas: 3,
-			},
-		},
+func TestEmptyReceiveDecrement(t *testing.T) {
+	config := newConfig()
+	config.MinEmptyReceives = 1
+	config.EmptyReceiveRatio = 5
+
+	// workers that rarely see an empty queue are busy
+	assert.Equal(t, int32(0), config.emptyReceiveDecrement(1, 3, 2, 100))
+	// some empty receives allow a normal scale down
+	assert.Equal(t, int32(1), config.emptyReceiveDecrement(1, 3, 30, 100))
+	// mostly empty receives double the scale down
+	assert.Equal(t, int32(2), config.emptyReceiveDecrement(1, 3, 600, 100))
+	assert.Equal(t, int32(2), config.emptyReceiveDecrement(1, 3, 30, 0))
+}
+
+func TestNumEmpty(t *testing.T) {
+	queue := &source.SQS{CloudWatchClient: NewMockCloudWatchClient(0, 0, 30)}
+	emptyReceives, ok, err := numEmpty(queue)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, float64(30), emptyReceives)
+
+	// sources without empty receives are not gated on them
+	_, ok, err = numEmpty(struct{ source.Source }{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// nor are sources that cannot tell at the moment
+	_, ok, err = numEmpty(&MockUnknownEmptyQueue{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestRunMaxConsumers(t *testing.T) {
+	// override the default config for testing
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 10
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 100)
+
+	// a topic with 4 partitions cannot use more than 4 consumers
+	go Run(p, &MockLimitedQueue{SQS: &source.SQS{SqsClient: s, CloudWatchClient: c}, Max: 4}, config, nil)
+
+	s.Client.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		Attributes: map[string]*string{"ApproximateNumberOfMessages": aws.String("100")},
+	})
+
+	time.Sleep(10 * time.Second)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas, "Number of replicas should be capped at the max consumers")
+}
+
+func TestRunKafkaSteadyLag(t *testing.T) {
+	// override the default config for testing
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	// Kafka reports no empty receives, so scaling down is not gated on them
+	config.MinEmptyReceives = 2
+	config.EmptyReceiveRatio = 5
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+
+	// the consumers keep up with 10 messages a second, 5 behind
+	k := &MockSteadyKafka{Start: time.Now(), PerSecond: 10, Lag: 5}
+	go Run(p, &kafka.KafkaClient{Client: k, Admin: k, Topic: "orders", Group: "workers"}, config, nil)
+
+	time.Sleep(10 * time.Second)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(config.MinPods), *deployment.Spec.Replicas, "A small steady lag should not prevent scaling down")
+}
+
+func TestRunMaxPodsReachedOnce(t *testing.T) {
+	// override the default config for testing
+	config := newConfig()
+	config.PollInterval.Duration = 1 * time.Second
+	config.ScaleDownCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpCoolPeriod.Duration = 1 * time.Second
+	config.ScaleUpTimeout.Duration = 1 * time.Second
+	config.ScaleUpMessages = 100
+	config.ScaleDownMessages = 10
+	config.HPACheckPeriod.Duration = 1 * time.Minute
+	config.RolloutPolicy = rolloutIgnore
+	config.MaxPods = 5
+	config.MinPods = 1
+	config.SurplusPods = -1
+
+	p := NewMockPodAutoScaler("test", "test", config.MaxPods, config.MinPods)
+	recorder := record.NewFakeRecorder(100)
+	p.Recorder = recorder
+	s := NewMockSqsClient()
+	c := NewMockCloudWatchClient(10, 10, 100)
+
+	go Run(p, &source.SQS{SqsClient: s, CloudWatchClient: c}, config, nil)
+
+	s.Client.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		Attributes: map[string]*string{"ApproximateNumberOfMessages": aws.String("100")},
+	})
+
+	time.Sleep(8 * time.Second)
+
+	reached := 0
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, "MaxPodsReached") {
+			reached++
+		}
 	}
+	assert.Equal(t, 1, reached, "MaxPodsReached should only be emitted when the max is reached")
+}
+
+func TestAwsConfig(t *testing.T) {
+	config := newConfig()
+	config.AwsRegion = "us-east-1"
+	config.AwsEndpoint = "http://localstack:4566"
+	config.AwsInsecureSkipVerify = true
+
+	cfg := config.awsConfig("")
+	assert.Equal(t, "us-east-1", *cfg.Region)
+	assert.Equal(t, "http://localstack:4566", *cfg.Endpoint)
+	assert.True(t, cfg.HTTPClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+
+	cfg = config.awsConfig("http://elasticmq:9324")
+	assert.Equal(t, "http://elasticmq:9324", *cfg.Endpoint)
+
+	config.KinesisEndpoint = "https://vpce-1234.kinesis.us-east-1.vpce.amazonaws.com"
+	cfg = config.awsConfig(config.KinesisEndpoint)
+	assert.Equal(t, config.KinesisEndpoint, *cfg.Endpoint)
+}
+
+func TestParseSqsQueue(t *testing.T) {
+	config := newConfig()
+
+	config.AwsRegion = ""
+	config.SqsQueueUrl = "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"
+	q, err := config.parseSqsQueue()
+	assert.Nil(t, err)
+	assert.Equal(t, "orders", q.Name)
+	assert.Equal(t, "eu-west-1", config.AwsRegion)
+
+	config.AwsRegion = "us-east-1"
+	_, err = config.parseSqsQueue()
+	assert.NotNil(t, err)
+
+	config.SqsQueue = "arn:aws:sqs:us-east-1:123456789012:orders"
+	_, err = config.parseSqsQueue()
+	assert.NotNil(t, err)
+
+	config.SqsQueueUrl = ""
+	q, err = config.parseSqsQueue()
+	assert.Nil(t, err)
+	assert.Equal(t, "", q.Url)
+	assert.Equal(t, "123456789012", q.Account)
+}
+
+func TestAwsSessionAssumeRole(t *testing.T) {
+	config := newConfig()
+	requests := 0
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		assert.Equal(t, "AssumeRole", r.Form.Get("Action"))
+		assert.Equal(t, roleSessionName, r.Form.Get("RoleSessionName"))
+
+		// the access key tells which role and external ID were assumed
+		w.Write([]byte(`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>` + strings.TrimPrefix(r.Form.Get("RoleArn"), "arn:aws:iam::") + "/" + r.Form.Get("ExternalId") + `</AccessKeyId>
+			<SecretAccessKey>assumed-secret</SecretAccessKey>
+			<SessionToken>token</SessionToken>
+			<Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>
+		</Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer sts.Close()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "BASE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "base-secret")
+	config.AwsRegion = "us-east-1"
+	config.AwsEndpoint = sts.URL
+	config.AwsRoleArn = "arn:aws:iam::123456789012:role/queue-reader"
+	config.AwsExternalId = "secret"
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	}()
+
+	sess := config.awsSession("", "")
+	creds, err := sess.Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012:role/queue-reader/secret", creds.AccessKeyID)
+
+	// the assumed credentials are cached
+	_, err = sess.Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+
+	// the role of a target replaces --aws-role-arn
+	sess = config.awsSession("arn:aws:iam::222222222222:role/stream-reader", "")
+	creds, err = sess.Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "222222222222:role/stream-reader/", creds.AccessKeyID)
 }
 
 func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max int, min int) *scale.PodAutoScaler {
-	mockClient := NewMockKubeClient()
+	mockClient := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesDeploymentName,
+			Namespace: kubernetesNamespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(3),
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          3,
+			UpdatedReplicas:   3,
+			ReadyReplicas:     3,
+			AvailableReplicas: 3,
+		},
+	})
+
+	// pods become available as soon as the deployment is scaled
+	mockClient.PrependReactor("update", "deployments", func(action ktesting.Action) (bool, runtime.Object, error) {
+		deployment := action.(ktesting.UpdateAction).GetObject().(*appsv1.Deployment)
+		deployment.Status.Replicas = *deployment.Spec.Replicas
+		deployment.Status.UpdatedReplicas = *deployment.Spec.Replicas
+		deployment.Status.ReadyReplicas = *deployment.Spec.Replicas
+		deployment.Status.AvailableReplicas = *deployment.Spec.Replicas
+		return false, nil, nil
+	})
 
 	return &scale.PodAutoScaler{
 		Client:     mockClient,
@@ -211,6 +745,10 @@ func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace s
 	}
 }
 
+func int32Ptr(x int32) *int32 {
+	return &x
+}
+
 type MockSQS struct {
 	QueueAttributes *sqs.GetQueueAttributesOutput
 }
@@ -220,14 +758,17 @@ func (m *MockSQS) GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueu
 }
 
 func (m *MockSQS) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
-	m.QueueAttributes = &sqs.GetQueueAttributesOutput{
-		Attributes: input.Attributes,
+	for name, value := range input.Attributes {
+		m.QueueAttributes.Attributes[name] = value
 	}
 	return &sqs.SetQueueAttributesOutput{}, nil
 }
 
 func NewMockSqsClient() *mainsqs.SqsClient {
-	Attributes := map[string]*string{"ApproximateNumberOfMessages": aws.String("50")}
+	Attributes := map[string]*string{
+		"ApproximateNumberOfMessages":           aws.String("50"),
+		"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+	}
 
 	return &mainsqs.SqsClient{
 		Client: &MockSQS{
@@ -238,3 +779,77 @@ func NewMockSqsClient() *mainsqs.SqsClient {
 		QueueUrl: "example.com",
 	}
 }
+
+type MockCloudWatch struct {
+	Metrics map[string]float64
+}
+
+func (m *MockCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	value := aws.Float64(m.Metrics[*input.MetricName])
+
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Datapoints: []*cloudwatch.Datapoint{
+			&cloudwatch.Datapoint{
+				Maximum: value,
+				Sum:     value,
+			},
+		},
+	}, nil
+}
+
+func NewMockCloudWatchClient(sent float64, deleted float64, empty float64) *maincloudwatch.CloudWatchClient {
+	return &maincloudwatch.CloudWatchClient{
+		Client: &MockCloudWatch{
+			Metrics: map[string]float64{
+				"ApproximateAgeOfOldestMessage": 10,
+				"NumberOfMessagesSent":          sent,
+				"NumberOfMessagesDeleted":       deleted,
+				"NumberOfEmptyReceives":         empty,
+			},
+		},
+		Queue: "example",
+	}
+}
+
+// MockSteadyKafka is a single partition topic produced to at a steady rate,
+// with the consumer group a steady number of messages behind
+type MockSteadyKafka struct {
+	Start     time.Time
+	PerSecond int64
+	Lag       int64
+}
+
+func (m *MockSteadyKafka) newest() int64 {
+	return 1000 + int64(time.Since(m.Start).Seconds()*float64(m.PerSecond))
+}
+
+func (m *MockSteadyKafka) Partitions(topic string) ([]int32, error) {
+	return []int32{0}, nil
+}
+
+func (m *MockSteadyKafka) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	return m.newest(), nil
+}
+
+func (m *MockSteadyKafka) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	resp := &sarama.OffsetFetchResponse{}
+	resp.AddBlock("orders", 0, &sarama.OffsetFetchResponseBlock{Offset: m.newest() - m.Lag, Err: sarama.ErrNoError})
+	return resp, nil
+}
+
+type MockUnknownEmptyQueue struct {
+	source.Source
+}
+
+func (m *MockUnknownEmptyQueue) NumEmpty() (float64, error) {
+	return 0, errors.Wrap(source.ErrNoEmptyReceives, "no blocked clients")
+}
+
+type MockLimitedQueue struct {
+	*source.SQS
+	Max int
+}
+
+func (m *MockLimitedQueue) MaxConsumers() (int, error) {
+	return m.Max, nil
+}
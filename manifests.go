@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/scale"
+)
+
+// Kinds of manifests printed by the manifests command
+const (
+	manifestKubernetes = "kubernetes"
+	manifestIAMPolicy  = "iam-policy"
+)
+
+// manifestOnlyFlags configure the manifests command and are not passed on to
+// the generated deployment
+var manifestOnlyFlags = map[string]bool{
+	"config":                true,
+	"manifest":              true,
+	"manifest-image":        true,
+	"manifest-iam-role-arn": true,
+}
+
+// policyDocument is an IAM policy
+type policyDocument struct {
+	Version   string
+	Statement []policyStatement
+}
+
+type policyStatement struct {
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+// printManifests prints the manifest selected by --manifest
+func printManifests(out io.Writer, config *Config) error {
+	switch config.ManifestKind {
+	case manifestKubernetes:
+		objects, err := kubernetesManifests(config)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				return errors.Wrap(err, "Failed to marshal manifest")
+			}
+			fmt.Fprintf(out, "---\n%s", data)
+		}
+		return nil
+	case manifestIAMPolicy:
+		policy, err := iamPolicy(config)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(policy, "", "    ")
+		if err != nil {
+			return errors.Wrap(err, "Failed to marshal IAM policy")
+		}
+		fmt.Fprintf(out, "%s\n", data)
+		return nil
+	default:
+		return errors.Errorf("Invalid --manifest %q, must be %s or %s", config.ManifestKind, manifestKubernetes, manifestIAMPolicy)
+	}
+}
+
+// kubernetesManifests returns the ServiceAccount, Role, RoleBinding and
+// Deployment of an autoscaler running with the flags set on the command line
+// or in the config, next to the deployment it scales
+func kubernetesManifests(config *Config) ([]runtime.Object, error) {
+	if config.KubernetesDeploymentName == "" {
+		return nil, errors.New("--kubernetes-deployment is required")
+	}
+
+	_, port, err := net.SplitHostPort(config.ListenAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid --listen-address %q", config.ListenAddress)
+	}
+	containerPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid --listen-address %q", config.ListenAddress)
+	}
+
+	name := "kube-sqs-autoscaler-" + config.KubernetesDeploymentName
+	labels := map[string]string{"app": name}
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: config.KubernetesNamespace,
+		Labels:    labels,
+	}
+
+	serviceAccount := &corev1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: meta,
+	}
+	if config.ManifestRoleArn != "" {
+		serviceAccount.ObjectMeta.Annotations = map[string]string{"eks.amazonaws.com/role-arn": config.ManifestRoleArn}
+	}
+
+	role := &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: meta,
+		Rules:      roleRules(config),
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: meta,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     name,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      name,
+			Namespace: config.KubernetesNamespace,
+		}},
+	}
+
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("200Mi"),
+	}
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString("http")},
+			},
+			PeriodSeconds: 10,
+		}
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: meta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			// two autoscalers must not scale the deployment at once
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: name,
+					Containers: []corev1.Container{{
+						Name:    "kube-sqs-autoscaler",
+						Image:   config.ManifestImage,
+						Command: []string{"/kube-sqs-autoscaler"},
+						Args:    deploymentArgs(config),
+						Ports: []corev1.ContainerPort{{
+							Name:          "http",
+							ContainerPort: int32(containerPort),
+						}},
+						LivenessProbe:  probe("/healthz"),
+						ReadinessProbe: probe("/readyz"),
+						Resources: corev1.ResourceRequirements{
+							Requests: resources,
+							Limits:   resources,
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	return []runtime.Object{serviceAccount, role, roleBinding, deployment}, nil
+}
+
+// roleRules returns the rules allowing exactly what the autoscaler does in the
+// namespace of the deployment
+func roleRules(config *Config) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups:     []string{"apps"},
+			Resources:     []string{"deployments"},
+			ResourceNames: []string{config.KubernetesDeploymentName},
+			Verbs:         []string{"get", "update"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"list"},
+		},
+		{
+			APIGroups: []string{"autoscaling"},
+			Resources: []string{"horizontalpodautoscalers"},
+			Verbs:     []string{"list"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "patch"},
+		},
+	}
+
+	if config.PersistState {
+		p := &scale.PodAutoScaler{Deployment: config.KubernetesDeploymentName, Namespace: config.KubernetesNamespace}
+		rules = append(rules,
+			rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{p.StateConfigMap()},
+				Verbs:         []string{"get", "update"},
+			},
+			// creating cannot be restricted by name
+			rbacv1.PolicyRule{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"create"},
+			},
+		)
+	}
+
+	return rules
+}
+
+// deploymentArgs returns the flags of the fields of the config not set to
+// their defaults, except those only configuring the manifests
+func deploymentArgs(config *Config) []string {
+	var args []string
+	defaults := newConfig().flags()
+	config.flags().VisitAll(func(f *flag.Flag) {
+		if !manifestOnlyFlags[f.Name] && f.Value.String() != defaults.Lookup(f.Name).Value.String() {
+			args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
+// iamPolicy returns the policy allowing exactly the AWS calls the autoscaler
+// makes for the queue. CloudWatch does not support resource level permissions
+// for reading metrics.
+func iamPolicy(config *Config) (*policyDocument, error) {
+	var statements []policyStatement
+
+	switch config.QueueSource {
+	case "sqs":
+		q, err := config.parseSqsQueue()
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid SQS queue")
+		}
+
+		actions := []string{"sqs:GetQueueAttributes"}
+		if q.Url == "" {
+			actions = append(actions, "sqs:GetQueueUrl")
+		}
+
+		account := q.Account
+		if account == "" {
+			account = "*"
+		}
+
+		statements = append(statements, policyStatement{
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: []string{fmt.Sprintf("arn:%s:sqs:%s:%s:%s", awsPartition(config.AwsRegion), config.AwsRegion, account, q.Name)},
+		})
+	case "kinesis":
+		if config.AwsRegion == "" {
+			return nil, errors.New("--aws-region is required for Kinesis streams")
+		}
+
+		// the stream does not tell its account
+		statements = append(statements, policyStatement{
+			Effect:   "Allow",
+			Action:   []string{"kinesis:DescribeStreamSummary"},
+			Resource: []string{fmt.Sprintf("arn:%s:kinesis:%s:*:stream/%s", awsPartition(config.AwsRegion), config.AwsRegion, config.KinesisStream)},
+		})
+	default:
+		return nil, errors.Errorf("Source %s needs no IAM policy", config.QueueSource)
+	}
+
+	statements = append(statements, policyStatement{
+		Effect:   "Allow",
+		Action:   []string{"cloudwatch:GetMetricStatistics"},
+		Resource: []string{"*"},
+	})
+
+	return &policyDocument{Version: "2012-10-17", Statement: statements}, nil
+}
+
+// awsPartition returns the partition of the region, aws for unknown regions
+func awsPartition(region string) string {
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return partition.ID()
+	}
+	return endpoints.AwsPartitionID
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestKubernetesManifests(t *testing.T) {
+	config := newConfig()
+	config.KubernetesDeploymentName = "orders-worker"
+	config.KubernetesNamespace = "orders"
+	config.PersistState = false
+
+	objects, err := kubernetesManifests(config)
+	assert.Nil(t, err)
+	assert.Len(t, objects, 4)
+
+	role := objects[1].(*rbacv1.Role)
+	assert.Equal(t, "orders", role.Namespace)
+	assert.Equal(t, []string{"orders-worker"}, role.Rules[0].ResourceNames)
+	assert.Equal(t, []string{"get", "update"}, role.Rules[0].Verbs)
+	for _, rule := range role.Rules {
+		assert.NotContains(t, rule.Resources, "configmaps")
+	}
+
+	deployment := objects[3].(*appsv1.Deployment)
+	assert.Equal(t, "apps/v1", deployment.APIVersion)
+	assert.Equal(t, appsv1.RecreateDeploymentStrategyType, deployment.Spec.Strategy.Type)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, int32(8080), container.Ports[0].ContainerPort)
+	assert.Equal(t, "/healthz", container.LivenessProbe.HTTPGet.Path)
+	assert.Equal(t, "/readyz", container.ReadinessProbe.HTTPGet.Path)
+
+	config.PersistState = true
+	objects, err = kubernetesManifests(config)
+	assert.Nil(t, err)
+	role = objects[1].(*rbacv1.Role)
+	assert.Equal(t, []string{"kube-sqs-autoscaler-orders-worker"}, role.Rules[4].ResourceNames)
+
+	config.KubernetesDeploymentName = ""
+	_, err = kubernetesManifests(config)
+	assert.NotNil(t, err)
+}
+
+func TestDeploymentArgs(t *testing.T) {
+	config := newConfig()
+	config.SqsQueueUrl = "https://sqs.us-west-2.amazonaws.com/123456789012/orders"
+	config.MaxPods = 10
+	config.MinPods = 1
+	config.ManifestImage = "orders/kube-sqs-autoscaler:1.0"
+
+	assert.Equal(t, []string{"--max-pods=10", "--sqs-queue-url=https://sqs.us-west-2.amazonaws.com/123456789012/orders"}, deploymentArgs(config))
+}
+
+func TestIAMPolicy(t *testing.T) {
+	config := newConfig()
+	config.SqsQueueUrl = "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"
+
+	policy, err := iamPolicy(config)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sqs:GetQueueAttributes"}, policy.Statement[0].Action)
+	assert.Equal(t, []string{"arn:aws:sqs:eu-west-1:123456789012:orders"}, policy.Statement[0].Resource)
+	assert.Equal(t, []string{"cloudwatch:GetMetricStatistics"}, policy.Statement[1].Action)
+
+	config.SqsQueueUrl = ""
+	config.SqsQueue = "orders"
+	config.AwsRegion = "us-gov-west-1"
+	policy, err = iamPolicy(config)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sqs:GetQueueAttributes", "sqs:GetQueueUrl"}, policy.Statement[0].Action)
+	assert.Equal(t, []string{"arn:aws-us-gov:sqs:us-gov-west-1:*:orders"}, policy.Statement[0].Resource)
+
+	config.QueueSource = "kinesis"
+	config.KinesisStream = "clicks"
+	policy, err = iamPolicy(config)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"arn:aws-us-gov:kinesis:us-gov-west-1:*:stream/clicks"}, policy.Statement[0].Resource)
+
+	config.QueueSource = "rabbitmq"
+	_, err = iamPolicy(config)
+	assert.NotNil(t, err)
+}
+
+func TestPrintManifests(t *testing.T) {
+	config := newConfig()
+	config.SqsQueueUrl = "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	config.KubernetesDeploymentName = "orders-worker"
+
+	var out bytes.Buffer
+	config.ManifestKind = manifestIAMPolicy
+	assert.Nil(t, printManifests(&out, config))
+
+	var policy policyDocument
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &policy))
+	assert.Equal(t, "2012-10-17", policy.Version)
+
+	out.Reset()
+	config.ManifestKind = manifestKubernetes
+	assert.Nil(t, printManifests(&out, config))
+	assert.Contains(t, out.String(), "kind: RoleBinding")
+	assert.Contains(t, out.String(), "---\napiVersion: apps/v1\nkind: Deployment\n")
+
+	config.ManifestKind = "helm"
+	assert.NotNil(t, printManifests(&out, config))
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	mu     sync.Mutex
+	gauges []*Gauge
+)
+
+// Gauge is a metric exported in the prometheus text format, with one value per
+// combination of label values
+type Gauge struct {
+	Name   string
+	Help   string
+	Labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge creates a gauge and registers it with Handler
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		Name:   name,
+		Help:   help,
+		Labels: labels,
+		values: make(map[string]float64),
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	gauges = append(gauges, g)
+
+	return g
+}
+
+func (g *Gauge) key(labelValues []string) string {
+	pairs := make([]string, len(g.Labels))
+	for i, label := range g.Labels {
+		value := ""
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", label, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set sets the value of the gauge for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+// Delete removes the value of the gauge for the given label values
+func (g *Gauge) Delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.values, g.key(labelValues))
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", g.Name, g.Help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.Name)
+
+	keys := make([]string, 0, len(g.values))
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(w, "%s %g\n", g.Name, g.values[key])
+		} else {
+			fmt.Fprintf(w, "%s{%s} %g\n", g.Name, key, g.values[key])
+		}
+	}
+}
+
+// Handler serves all registered metrics in the prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, g := range gauges {
+			g.write(w)
+		}
+	})
+}
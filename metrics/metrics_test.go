@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	g := NewGauge("test_pods", "Number of test pods", "namespace", "deployment")
+	g.Set(2, "test", "b")
+	g.Set(1, "test", "a")
+	g.Set(3, "test", "c")
+	g.Delete("test", "c")
+
+	r := httptest.NewRecorder()
+	Handler().ServeHTTP(r, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, `# HELP test_pods Number of test pods
+# TYPE test_pods gauge
+test_pods{namespace="test",deployment="a"} 1
+test_pods{namespace="test",deployment="b"} 2
+`, r.Body.String())
+}
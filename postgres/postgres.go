@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// minSampleInterval is how long a backlog is reused, so the calls of one poll
+// run the query once
+const minSampleInterval = time.Second
+
+type DB interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Backlog is the result of the query at a point in time
+type Backlog struct {
+	Time time.Time
+	// Pending is the number of pending rows
+	Pending int64
+	// Age is the age of the oldest pending row in seconds, 0 without rows
+	Age float64
+}
+
+// PostgresClient reads the backlog of a table used as a job queue. The query
+// must return the number of pending rows and the age of the oldest one in
+// seconds, e.g.
+//
+//	SELECT count(*), EXTRACT(EPOCH FROM now() - min(created_at)) FROM jobs WHERE state = $1
+//
+// Rates are computed from the change of the number of pending rows between
+// calls.
+type PostgresClient struct {
+	DB      DB
+	Query   string
+	Args    []interface{}
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	last  *Backlog
+	rates source.Rates
+}
+
+func NewPostgresClient(dsn string, query string, args []interface{}, timeout time.Duration) (*PostgresClient, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open database")
+	}
+	db.SetMaxOpenConns(1)
+
+	return &PostgresClient{
+		DB:      db,
+		Query:   query,
+		Args:    args,
+		Timeout: timeout,
+	}, nil
+}
+
+// GetBacklog runs the query in a read only transaction, or returns the last
+// backlog if it was read less than minSampleInterval ago. The query is
+// cancelled after the timeout so a slow database does not stall scaling.
+func (p *PostgresClient) GetBacklog() (*Backlog, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last != nil && time.Since(p.last.Time) < minSampleInterval {
+		return p.last, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to query pending rows")
+	}
+	defer tx.Rollback()
+
+	var pending int64
+	var age sql.NullFloat64
+	if err := tx.QueryRowContext(ctx, p.Query, p.Args...).Scan(&pending, &age); err != nil {
+		return nil, errors.Wrap(err, "Failed to query pending rows")
+	}
+
+	backlog := &Backlog{Time: time.Now(), Pending: pending, Age: age.Float64}
+	p.rates.AddLength(backlog.Time, pending)
+	p.last = backlog
+
+	return backlog, nil
+}
+
+func (p *PostgresClient) NumMessages() (int, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Pending), nil
+}
+
+// NumInFlight is always 0, rows being processed are not distinguished
+func (p *PostgresClient) NumInFlight() (int, error) {
+	return 0, nil
+}
+
+func (p *PostgresClient) Age() (float64, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Age, nil
+}
+
+func (p *PostgresClient) NumSent() (float64, error) {
+	if _, err := p.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	produced, _ := p.rates.PerMinute()
+	return produced, nil
+}
+
+func (p *PostgresClient) NumDeleted() (float64, error) {
+	if _, err := p.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	_, consumed := p.rates.PerMinute()
+	return consumed, nil
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklog(t *testing.T) {
+	p, mock := NewMockPostgresClient()
+	mock.Row = []driver.Value{int64(40), 90.5}
+
+	num, err := p.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 40, num)
+
+	age, err := p.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 90.5, age)
+
+	assert.True(t, mock.ReadOnly)
+	assert.Equal(t, []driver.NamedValue{{Ordinal: 1, Value: "pending"}}, mock.Args)
+}
+
+func TestNoPendingRows(t *testing.T) {
+	p, mock := NewMockPostgresClient()
+	mock.Row = []driver.Value{int64(0), nil}
+
+	age, err := p.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, age)
+
+	num, err := p.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, num)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	p, mock := NewMockPostgresClient()
+	mock.Row = []driver.Value{int64(40), 90.5}
+	mock.Delay = time.Second
+	p.Timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	_, err := p.NumMessages()
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+// MockDriver is a database driver answering every query with Row
+type MockDriver struct {
+	Row      []driver.Value
+	Delay    time.Duration
+	ReadOnly bool
+	Args     []driver.NamedValue
+}
+
+func (d *MockDriver) Open(name string) (driver.Conn, error) {
+	return &mockConn{d}, nil
+}
+
+type mockConn struct {
+	d *MockDriver
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *mockConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.d.ReadOnly = opts.ReadOnly
+	return c, nil
+}
+
+func (c *mockConn) Commit() error {
+	return nil
+}
+
+func (c *mockConn) Rollback() error {
+	return nil
+}
+
+func (c *mockConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.Args = args
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(c.d.Delay):
+	}
+	return &mockRows{row: c.d.Row}, nil
+}
+
+type mockRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *mockRows) Columns() []string {
+	return []string{"count", "age"}
+}
+
+func (r *mockRows) Close() error {
+	return nil
+}
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+var mockDriver = &MockDriver{}
+
+func init() {
+	sql.Register("mockpostgres", mockDriver)
+}
+
+func NewMockPostgresClient() (*PostgresClient, *MockDriver) {
+	*mockDriver = MockDriver{}
+	db, _ := sql.Open("mockpostgres", "")
+
+	return &PostgresClient{
+		DB:      db,
+		Query:   "SELECT count(*), EXTRACT(EPOCH FROM now() - min(created_at)) FROM jobs WHERE state = $1",
+		Args:    []interface{}{"pending"},
+		Timeout: time.Second,
+	}, mockDriver
+}
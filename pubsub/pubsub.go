@@ -0,0 +1,206 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// Subscription metrics in Cloud Monitoring
+const (
+	UndeliveredMessages = "pubsub.googleapis.com/subscription/num_undelivered_messages"
+	OldestUnackedAge    = "pubsub.googleapis.com/subscription/oldest_unacked_message_age"
+	AckedMessages       = "pubsub.googleapis.com/subscription/ack_message_count"
+)
+
+// minSampleInterval is how long metrics are reused, so the calls of one poll
+// read the same values
+const minSampleInterval = time.Second
+
+// Fetcher reads the latest value of a metric of a subscription. A gauge is its
+// maximum over the last minute, a count its sum over the last minute.
+type Fetcher interface {
+	Metric(metricType string, subscription string) (float64, error)
+}
+
+// Backlog is the state of a subscription at a point in time
+type Backlog struct {
+	Time        time.Time
+	Undelivered int64
+	OldestAge   float64
+	Acked       float64
+}
+
+// PubSubClient reads the backlog of a Pub/Sub subscription
+type PubSubClient struct {
+	Fetcher      Fetcher
+	Subscription string
+
+	mu    sync.Mutex
+	last  *Backlog
+	rates source.Rates
+}
+
+func NewPubSubClient(project string, subscription string) (*PubSubClient, error) {
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/monitoring.read")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get Google credentials")
+	}
+
+	return &PubSubClient{
+		Fetcher: &MonitoringFetcher{
+			Client:  client,
+			Url:     "https://monitoring.googleapis.com",
+			Project: project,
+		},
+		Subscription: subscription,
+	}, nil
+}
+
+// GetBacklog reads the subscription metrics, or returns the last ones if they
+// were read less than minSampleInterval ago
+func (p *PubSubClient) GetBacklog() (*Backlog, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last != nil && time.Since(p.last.Time) < minSampleInterval {
+		return p.last, nil
+	}
+
+	undelivered, err := p.Fetcher.Metric(UndeliveredMessages, p.Subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	age, err := p.Fetcher.Metric(OldestUnackedAge, p.Subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	acked, err := p.Fetcher.Metric(AckedMessages, p.Subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	backlog := &Backlog{Time: time.Now(), Undelivered: int64(undelivered), OldestAge: age, Acked: acked}
+	p.rates.AddLength(backlog.Time, backlog.Undelivered)
+	p.last = backlog
+
+	return backlog, nil
+}
+
+func (p *PubSubClient) NumMessages() (int, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Undelivered), nil
+}
+
+// NumInFlight is always 0, undelivered messages include the unacked ones
+func (p *PubSubClient) NumInFlight() (int, error) {
+	return 0, nil
+}
+
+func (p *PubSubClient) Age() (float64, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.OldestAge, nil
+}
+
+// NumSent estimates the messages published to the subscription as the acked
+// messages plus the growth of the backlog
+func (p *PubSubClient) NumSent() (float64, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+
+	growth, shrink := p.rates.PerMinute()
+	return math.Max(backlog.Acked+growth-shrink, 0), nil
+}
+
+func (p *PubSubClient) NumDeleted() (float64, error) {
+	backlog, err := p.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return backlog.Acked, nil
+}
+
+// MonitoringFetcher reads metrics from the Cloud Monitoring API
+type MonitoringFetcher struct {
+	Client  *http.Client
+	Url     string
+	Project string
+}
+
+type timeSeriesList struct {
+	TimeSeries []struct {
+		Points []struct {
+			Value struct {
+				Int64Value  *string  `json:"int64Value"`
+				DoubleValue *float64 `json:"doubleValue"`
+			} `json:"value"`
+		} `json:"points"`
+	} `json:"timeSeries"`
+}
+
+func (m *MonitoringFetcher) Metric(metricType string, subscription string) (float64, error) {
+	end := time.Now()
+	aligner := "ALIGN_MAX"
+	if metricType == AckedMessages {
+		aligner = "ALIGN_SUM"
+	}
+
+	query := url.Values{}
+	query.Set("filter", fmt.Sprintf(`metric.type = %q AND resource.labels.subscription_id = %q`, metricType, subscription))
+	// Pub/Sub metrics are delayed by up to a few minutes
+	query.Set("interval.startTime", end.Add(-5*time.Minute).UTC().Format(time.RFC3339))
+	query.Set("interval.endTime", end.UTC().Format(time.RFC3339))
+	query.Set("aggregation.alignmentPeriod", "60s")
+	query.Set("aggregation.perSeriesAligner", aligner)
+
+	resp, err := m.Client.Get(fmt.Sprintf("%s/v3/projects/%s/timeSeries?%s", m.Url, m.Project, query.Encode()))
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to get %s from Cloud Monitoring", metricType)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("Failed to get %s from Cloud Monitoring: %s", metricType, resp.Status)
+	}
+
+	list := &timeSeriesList{}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return 0, errors.Wrapf(err, "Failed to decode %s from Cloud Monitoring", metricType)
+	}
+
+	// no time series means the subscription saw no activity
+	if len(list.TimeSeries) == 0 || len(list.TimeSeries[0].Points) == 0 {
+		return 0, nil
+	}
+
+	// points are returned newest first
+	value := list.TimeSeries[0].Points[0].Value
+	switch {
+	case value.Int64Value != nil:
+		return strconv.ParseFloat(*value.Int64Value, 64)
+	case value.DoubleValue != nil:
+		return *value.DoubleValue, nil
+	}
+	return 0, errors.Errorf("Failed to get %s from Cloud Monitoring: no value", metricType)
+}
@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+func TestBacklog(t *testing.T) {
+	p := NewMockPubSubClient(map[string]float64{
+		UndeliveredMessages: 120,
+		OldestUnackedAge:    45,
+		AckedMessages:       30,
+	})
+
+	num, err := p.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 120, num)
+
+	age, err := p.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 45.0, age)
+
+	deleted, err := p.NumDeleted()
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, deleted)
+
+	// the backlog was 100 half a minute ago
+	p.last.Time = time.Now().Add(-30 * time.Second)
+	p.rates = source.Rates{}
+	p.rates.AddLength(p.last.Time, 100)
+
+	sent, err := p.NumSent()
+	assert.Nil(t, err)
+	assert.InDelta(t, 70.0, sent, 0.5)
+}
+
+func TestMonitoringFetcher(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/projects/test/timeSeries", r.URL.Path)
+		query = r.URL.Query().Get("filter")
+		w.Write([]byte(`{"timeSeries": [{"points": [{"value": {"int64Value": "42"}}, {"value": {"int64Value": "40"}}]}]}`))
+	}))
+	defer server.Close()
+
+	m := &MonitoringFetcher{Client: server.Client(), Url: server.URL, Project: "test"}
+	value, err := m.Metric(UndeliveredMessages, "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 42.0, value)
+	assert.Equal(t, `metric.type = "pubsub.googleapis.com/subscription/num_undelivered_messages" AND resource.labels.subscription_id = "orders"`, query)
+}
+
+func TestMonitoringFetcherNoTimeSeries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	m := &MonitoringFetcher{Client: server.Client(), Url: server.URL, Project: "test"}
+	value, err := m.Metric(AckedMessages, "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, value)
+}
+
+type MockFetcher struct {
+	Metrics map[string]float64
+}
+
+func (m *MockFetcher) Metric(metricType string, subscription string) (float64, error) {
+	return m.Metrics[metricType], nil
+}
+
+func NewMockPubSubClient(metrics map[string]float64) *PubSubClient {
+	return &PubSubClient{
+		Fetcher:      &MockFetcher{Metrics: metrics},
+		Subscription: "orders",
+	}
+}
@@ -0,0 +1,98 @@
+package rabbitmq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumMessages(t *testing.T) {
+	r, server := NewMockRabbitMQClient(`{"messages_ready": 50, "messages_unacknowledged": 7}`)
+	defer server.Close()
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 50, num)
+
+	num, err = r.NumInFlight()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, num)
+}
+
+func TestRates(t *testing.T) {
+	r, server := NewMockRabbitMQClient(`{"message_stats": {"publish_details": {"rate": 2.5}, "ack_details": {"rate": 0.5}}}`)
+	defer server.Close()
+
+	sent, err := r.NumSent()
+	assert.Nil(t, err)
+	assert.Equal(t, 150.0, sent)
+
+	deleted, err := r.NumDeleted()
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, deleted)
+}
+
+func TestGetQueueIsCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		queueHandler(`{"messages_ready": 50}`).ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	r := NewRabbitMQClient(server.URL, "/", "orders")
+	r.NumMessages()
+	r.NumSent()
+	r.Age()
+	assert.Equal(t, 1, requests, "one poll reads the queue once")
+
+	r.lastTime = r.lastTime.Add(-minSampleInterval)
+	r.NumMessages()
+	assert.Equal(t, 2, requests)
+}
+
+func TestAge(t *testing.T) {
+	r, server := NewMockRabbitMQClient(`{"head_message_timestamp": 0}`)
+	defer server.Close()
+
+	age, err := r.Age()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, age)
+
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	r2, server2 := NewMockRabbitMQClient(`{"head_message_timestamp": ` + timestamp + `}`)
+	defer server2.Close()
+
+	age, err = r2.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 60.0, age, 2)
+}
+
+func TestGetQueueError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	r := NewRabbitMQClient(server.URL, "/", "orders")
+	_, err := r.NumMessages()
+	assert.NotNil(t, err)
+}
+
+func queueHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/queues/%2F/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+}
+
+func NewMockRabbitMQClient(body string) (*RabbitMQClient, *httptest.Server) {
+	server := httptest.NewServer(queueHandler(body))
+	return NewRabbitMQClient(server.URL, "/", "orders"), server
+}
@@ -0,0 +1,366 @@
+package redis
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+// minSampleInterval is how long a backlog is reused, so the calls of one poll
+// read the same backlog
+const minSampleInterval = time.Second
+
+// maxCountedLag caps the entries counted to find the lag of a consumer group
+// before Redis 7, which does not report it
+const maxCountedLag = 10000
+
+// blockingCommands are the commands workers block in waiting for entries, by
+// whether they read a list or a stream
+var blockingCommands = map[bool]map[string]bool{
+	false: {"blpop": true, "brpop": true, "brpoplpush": true, "blmove": true, "blmpop": true},
+	true:  {"xread": true, "xreadgroup": true},
+}
+
+type Redis interface {
+	Do(command string, args ...interface{}) (interface{}, error)
+}
+
+// pool runs each command on a connection of a redigo pool
+type pool struct {
+	*redis.Pool
+}
+
+func (p *pool) Do(command string, args ...interface{}) (interface{}, error) {
+	conn := p.Get()
+	defer conn.Close()
+	return conn.Do(command, args...)
+}
+
+// Backlog is the state of a list or stream at a point in time
+type Backlog struct {
+	Time time.Time
+	// Waiting is the number of entries not yet delivered to a worker
+	Waiting int64
+	// Pending is the number of stream entries delivered but not acknowledged
+	Pending int64
+	// Oldest is the time of the oldest waiting or pending stream entry
+	Oldest time.Time
+	// Blocked is the number of clients of the database blocked in a command
+	// waiting for entries of the kind of Key, or -1 if unknown
+	Blocked int64
+}
+
+// RedisClient reads the backlog of a Redis list, or of a consumer group of a
+// Redis stream. Rates are computed from the change of the backlog between
+// calls.
+type RedisClient struct {
+	Client Redis
+	// DB is the database selected by the url
+	DB  int
+	Key string
+	// Group is the consumer group of the stream, or empty for a list
+	Group string
+
+	mu    sync.Mutex
+	last  *Backlog
+	rates source.Rates
+}
+
+func NewRedisClient(redisUrl string, key string, group string) *RedisClient {
+	// an invalid url fails to dial later
+	db := 0
+	if u, err := url.Parse(redisUrl); err == nil {
+		db, _ = strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+	}
+
+	return &RedisClient{
+		Client: &pool{&redis.Pool{
+			MaxIdle:     1,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(redisUrl, redis.DialConnectTimeout(10*time.Second), redis.DialReadTimeout(10*time.Second))
+			},
+		}},
+		DB:    db,
+		Key:   key,
+		Group: group,
+	}
+}
+
+// GetBacklog reads the backlog, or returns the last one if it was read less
+// than minSampleInterval ago
+func (r *RedisClient) GetBacklog() (*Backlog, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last != nil && time.Since(r.last.Time) < minSampleInterval {
+		return r.last, nil
+	}
+
+	var backlog *Backlog
+	var err error
+	if r.Group == "" {
+		backlog, err = r.listBacklog()
+	} else {
+		backlog, err = r.streamBacklog()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the backlog is still known without the clients
+	backlog.Blocked, err = r.blockedClients()
+	if err != nil {
+		log.Warnf("Failed to count blocked clients: %v", err)
+		backlog.Blocked = -1
+	}
+
+	r.last = backlog
+	return backlog, nil
+}
+
+func (r *RedisClient) listBacklog() (*Backlog, error) {
+	length, err := redis.Int64(r.Client.Do("LLEN", r.Key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get length of list %s", r.Key)
+	}
+
+	// Redis does not count the entries pushed to and popped from a list
+	backlog := &Backlog{Time: time.Now(), Waiting: length}
+	r.rates.AddLength(backlog.Time, length)
+
+	return backlog, nil
+}
+
+func (r *RedisClient) streamBacklog() (*Backlog, error) {
+	stream, err := fields(r.Client.Do("XINFO", "STREAM", r.Key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get stream %s", r.Key)
+	}
+
+	groups, err := redis.Values(r.Client.Do("XINFO", "GROUPS", r.Key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get consumer groups of stream %s", r.Key)
+	}
+
+	var group map[string]interface{}
+	for _, g := range groups {
+		f, err := fields(g, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get consumer groups of stream %s", r.Key)
+		}
+		if name, _ := redis.String(f["name"], nil); name == r.Group {
+			group = f
+		}
+	}
+	if group == nil {
+		return nil, errors.Errorf("Consumer group %s of stream %s not found", r.Group, r.Key)
+	}
+
+	// entries-added, entries-read and lag are only reported since Redis 7.
+	// Before, the stream length is the best guess of the entries added and
+	// the entries after the last delivered one are counted.
+	added, err := redis.Int64(stream["entries-added"], nil)
+	if err != nil {
+		added, _ = redis.Int64(stream["length"], nil)
+	}
+	lastDelivered, _ := redis.String(group["last-delivered-id"], nil)
+	read, readErr := redis.Int64(group["entries-read"], nil)
+	lag, lagErr := redis.Int64(group["lag"], nil)
+	switch {
+	case lagErr == nil:
+	case readErr == nil:
+		// lag is nil when Redis cannot compute it, e.g. after deletions
+		lag = 0
+		if added > read {
+			lag = added - read
+		}
+	default:
+		entries, err := redis.Values(r.Client.Do("XRANGE", r.Key, nextID(lastDelivered), "+", "COUNT", maxCountedLag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to count undelivered entries of stream %s", r.Key)
+		}
+		lag = int64(len(entries))
+	}
+	if readErr != nil {
+		read = added - lag
+	}
+	pending, _ := redis.Int64(group["pending"], nil)
+
+	backlog := &Backlog{Time: time.Now(), Waiting: lag, Pending: pending}
+	r.rates.Add(source.Sample{Time: backlog.Time, Produced: added, Consumed: read - pending})
+
+	// the oldest entry is the oldest pending one, or else the first one not
+	// delivered yet
+	oldest := ""
+	if pending > 0 {
+		summary, err := redis.Values(r.Client.Do("XPENDING", r.Key, r.Group))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get pending entries of stream %s", r.Key)
+		}
+		if len(summary) > 1 {
+			oldest, _ = redis.String(summary[1], nil)
+		}
+	} else if lag > 0 {
+		entries, err := redis.Values(r.Client.Do("XRANGE", r.Key, nextID(lastDelivered), "+", "COUNT", 1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get entries of stream %s", r.Key)
+		}
+		if len(entries) > 0 {
+			entry, _ := redis.Values(entries[0], nil)
+			if len(entry) > 0 {
+				oldest, _ = redis.String(entry[0], nil)
+			}
+		}
+	}
+	backlog.Oldest = idTime(oldest)
+
+	return backlog, nil
+}
+
+func (r *RedisClient) NumMessages() (int, error) {
+	backlog, err := r.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Waiting), nil
+}
+
+// NumInFlight returns the number of stream entries delivered but not yet
+// acknowledged, always 0 for a list
+func (r *RedisClient) NumInFlight() (int, error) {
+	backlog, err := r.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+	return int(backlog.Pending), nil
+}
+
+// Age returns the age of the oldest stream entry, from its ID. It is always 0
+// for a list.
+func (r *RedisClient) Age() (float64, error) {
+	backlog, err := r.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+
+	if backlog.Oldest.IsZero() {
+		return 0, nil
+	}
+	return time.Since(backlog.Oldest).Seconds(), nil
+}
+
+func (r *RedisClient) NumSent() (float64, error) {
+	if _, err := r.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	produced, _ := r.rates.PerMinute()
+	return produced, nil
+}
+
+func (r *RedisClient) NumDeleted() (float64, error) {
+	if _, err := r.GetBacklog(); err != nil {
+		return 0, err
+	}
+
+	_, consumed := r.rates.PerMinute()
+	return consumed, nil
+}
+
+// NumEmpty counts each worker blocked waiting for entries, e.g. in BLPOP or
+// XREADGROUP BLOCK, as one empty receive per minute while nothing is waiting.
+// Workers polling with RPOP or LMOVE are never blocked, so without blocked
+// clients it returns source.ErrNoEmptyReceives rather than busy workers.
+func (r *RedisClient) NumEmpty() (float64, error) {
+	backlog, err := r.GetBacklog()
+	if err != nil {
+		return 0, err
+	}
+
+	if backlog.Waiting > 0 || backlog.Blocked <= 0 {
+		return 0, source.ErrNoEmptyReceives
+	}
+	return float64(backlog.Blocked), nil
+}
+
+// blockedClients counts the clients of the database blocked in a command
+// reading the kind of Key. The key a client blocks on is not listed, so
+// clients blocked on other keys of the database are counted too.
+func (r *RedisClient) blockedClients() (int64, error) {
+	list, err := redis.String(r.Client.Do("CLIENT", "LIST"))
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to list clients of Redis")
+	}
+
+	commands := blockingCommands[r.Group != ""]
+	db := strconv.Itoa(r.DB)
+
+	blocked := int64(0)
+	for _, line := range strings.Split(list, "\n") {
+		client := make(map[string]string)
+		for _, field := range strings.Fields(line) {
+			if i := strings.Index(field, "="); i > 0 {
+				client[field[:i]] = field[i+1:]
+			}
+		}
+
+		if strings.Contains(client["flags"], "b") && client["db"] == db && commands[client["cmd"]] {
+			blocked++
+		}
+	}
+	return blocked, nil
+}
+
+// fields converts a reply of alternating field names and values to a map
+func fields(reply interface{}, err error) (map[string]interface{}, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("Expected an even number of values")
+	}
+
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		name, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = values[i+1]
+	}
+	return m, nil
+}
+
+// nextID returns the stream entry ID following id, as exclusive ranges are
+// not supported before Redis 6.2
+func nextID(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "-"
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "-"
+	}
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// idTime returns the time a stream entry was added, from its ID
+func idTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
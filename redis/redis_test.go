@@ -0,0 +1,241 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/source"
+)
+
+func TestList(t *testing.T) {
+	r := NewMockRedisClient("", map[string]interface{}{"LLEN jobs": int64(40)})
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 40, num)
+
+	num, err = r.NumInFlight()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, num)
+
+	// waiting entries tell nothing about the blocked clients
+	_, err = r.NumEmpty()
+	assert.Equal(t, source.ErrNoEmptyReceives, err)
+
+	// the list was read 30 seconds ago, and grew by 10 since
+	r.last.Time = time.Now().Add(-30 * time.Second)
+	r.rates = source.Rates{}
+	r.rates.AddLength(r.last.Time, 40)
+	r.Client.(*MockRedis).Replies["LLEN jobs"] = int64(50)
+
+	sent, err := r.NumSent()
+	assert.Nil(t, err)
+	assert.InDelta(t, 20.0, sent, 0.1)
+
+	deleted, err := r.NumDeleted()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, deleted)
+}
+
+func TestListEmpty(t *testing.T) {
+	r := NewMockRedisClient("", map[string]interface{}{"LLEN jobs": int64(0)})
+
+	empty, err := r.NumEmpty()
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, empty)
+}
+
+func TestListPolled(t *testing.T) {
+	r := NewMockRedisClient("", map[string]interface{}{"LLEN jobs": int64(0)})
+	r.Client.(*MockRedis).Replies["CLIENT LIST"] = []byte("id=3 addr=10.0.0.1:50000 flags=N db=0 cmd=rpop\n")
+
+	// workers polling with RPOP are never blocked, so they may still be busy
+	_, err := r.NumEmpty()
+	assert.Equal(t, source.ErrNoEmptyReceives, err)
+}
+
+func TestClientListFailure(t *testing.T) {
+	r := NewMockRedisClient("", map[string]interface{}{"LLEN jobs": int64(0)})
+	delete(r.Client.(*MockRedis).Replies, "CLIENT LIST")
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, num)
+
+	_, err = r.NumEmpty()
+	assert.Equal(t, source.ErrNoEmptyReceives, err)
+}
+
+func TestStream(t *testing.T) {
+	oldest := time.Now().Add(-time.Minute)
+	pendingID := fmt.Sprintf("%d-0", oldest.UnixNano()/int64(time.Millisecond))
+
+	r := NewMockRedisClient("workers", map[string]interface{}{
+		"XINFO STREAM jobs": []interface{}{
+			[]byte("length"), int64(100),
+			[]byte("entries-added"), int64(1000),
+		},
+		"XINFO GROUPS jobs": []interface{}{
+			[]interface{}{
+				[]byte("name"), []byte("other"),
+				[]byte("pending"), int64(0),
+				[]byte("lag"), int64(0),
+			},
+			[]interface{}{
+				[]byte("name"), []byte("workers"),
+				[]byte("consumers"), int64(3),
+				[]byte("pending"), int64(5),
+				[]byte("last-delivered-id"), []byte("1-0"),
+				[]byte("entries-read"), int64(980),
+				[]byte("lag"), int64(20),
+			},
+		},
+		"XPENDING jobs workers": []interface{}{int64(5), []byte(pendingID), []byte("2-0"), []interface{}{}},
+	})
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 20, num)
+
+	num, err = r.NumInFlight()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, num)
+
+	age, err := r.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 60.0, age, 2)
+}
+
+func TestStreamWithoutLag(t *testing.T) {
+	first := time.Now().Add(-2 * time.Minute)
+	firstID := fmt.Sprintf("%d-0", first.UnixNano()/int64(time.Millisecond))
+
+	r := NewMockRedisClient("workers", map[string]interface{}{
+		"XINFO STREAM jobs": []interface{}{
+			[]byte("length"), int64(100),
+			[]byte("entries-added"), int64(1000),
+		},
+		"XINFO GROUPS jobs": []interface{}{
+			[]interface{}{
+				[]byte("name"), []byte("workers"),
+				[]byte("pending"), int64(0),
+				[]byte("last-delivered-id"), []byte("1-3"),
+				[]byte("entries-read"), int64(990),
+				[]byte("lag"), nil,
+			},
+		},
+		"XRANGE jobs 1-4 + COUNT 1": []interface{}{
+			[]interface{}{[]byte(firstID), []interface{}{[]byte("job"), []byte("a")}},
+		},
+	})
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, num)
+
+	age, err := r.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 120.0, age, 2)
+}
+
+func TestStreamBeforeRedis7(t *testing.T) {
+	first := time.Now().Add(-2 * time.Minute)
+	firstID := fmt.Sprintf("%d-0", first.UnixNano()/int64(time.Millisecond))
+	entry := []interface{}{[]byte(firstID), []interface{}{[]byte("job"), []byte("a")}}
+
+	// Redis 6 reports neither entries-read nor lag
+	r := NewMockRedisClient("workers", map[string]interface{}{
+		"XINFO STREAM jobs": []interface{}{
+			[]byte("length"), int64(100),
+		},
+		"XINFO GROUPS jobs": []interface{}{
+			[]interface{}{
+				[]byte("name"), []byte("workers"),
+				[]byte("pending"), int64(0),
+				[]byte("last-delivered-id"), []byte("5-0"),
+			},
+		},
+		"XRANGE jobs 5-1 + COUNT 10000": []interface{}{entry, entry, entry},
+		"XRANGE jobs 5-1 + COUNT 1":     []interface{}{entry},
+	})
+
+	num, err := r.NumMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, num, "the undelivered entries are counted")
+
+	age, err := r.Age()
+	assert.Nil(t, err)
+	assert.InDelta(t, 120.0, age, 2)
+}
+
+func TestStreamEmpty(t *testing.T) {
+	r := NewMockRedisClient("workers", map[string]interface{}{
+		"XINFO STREAM jobs": []interface{}{[]byte("length"), int64(0)},
+		"XINFO GROUPS jobs": []interface{}{
+			[]interface{}{
+				[]byte("name"), []byte("workers"),
+				[]byte("pending"), int64(0),
+				[]byte("entries-read"), int64(0),
+				[]byte("lag"), int64(0),
+			},
+		},
+	})
+
+	// only clients blocked reading a stream count
+	empty, err := r.NumEmpty()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, empty)
+}
+
+func TestStreamMissingGroup(t *testing.T) {
+	r := NewMockRedisClient("missing", map[string]interface{}{
+		"XINFO STREAM jobs": []interface{}{[]byte("length"), int64(0)},
+		"XINFO GROUPS jobs": []interface{}{},
+	})
+
+	_, err := r.NumMessages()
+	assert.NotNil(t, err)
+}
+
+func TestNextID(t *testing.T) {
+	assert.Equal(t, "1526919030474-56", nextID("1526919030474-55"))
+	assert.Equal(t, "-", nextID("0"))
+}
+
+type MockRedis struct {
+	Replies map[string]interface{}
+}
+
+func (m *MockRedis) Do(command string, args ...interface{}) (interface{}, error) {
+	key := command
+	for _, arg := range args {
+		key += " " + fmt.Sprint(arg)
+	}
+
+	reply, ok := m.Replies[key]
+	if !ok {
+		return nil, fmt.Errorf("unexpected command %s", key)
+	}
+	return reply, nil
+}
+
+func NewMockRedisClient(group string, replies map[string]interface{}) *RedisClient {
+	replies["CLIENT LIST"] = []byte(strings.Join([]string{
+		"id=3 addr=10.0.0.1:50000 flags=b db=0 cmd=blpop",
+		"id=4 addr=10.0.0.2:50000 flags=b db=0 cmd=brpoplpush",
+		"id=5 addr=10.0.0.3:50000 flags=b db=1 cmd=blpop",
+		"id=6 addr=10.0.0.4:50000 flags=b db=0 cmd=xreadgroup",
+		"id=7 addr=10.0.0.5:50000 flags=N db=0 cmd=client|list",
+		"",
+	}, "\n"))
+
+	return &RedisClient{
+		Client: &MockRedis{Replies: replies},
+		Key:    "jobs",
+		Group:  group,
+	}
+}
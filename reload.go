@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/metrics"
+)
+
+// reloadableFlags are the thresholds Run reads on every poll, which can be
+// changed in the config without restarting and losing the learned state
+var reloadableFlags = map[string]bool{
+	"poll-period":                    true,
+	"scale-down-cool-down":           true,
+	"scale-up-cool-down":             true,
+	"scale-up-timeout":               true,
+	"manual-scale-back-off":          true,
+	"hpa-check-period":               true,
+	"rollout-policy":                 true,
+	"rollout-max-wait":               true,
+	"acceptable-age":                 true,
+	"scale-down-min-empty-receives":  true,
+	"scale-down-empty-receive-ratio": true,
+	"scale-up-messages":              true,
+	"scale-down-messages":            true,
+	"max-pods":                       true,
+	"min-pods":                       true,
+	"unschedulable-surplus-pods":     true,
+	"allow-hpa-coexistence":          true,
+}
+
+var (
+	configGeneration   = metrics.NewGauge("kube_sqs_autoscaler_config_generation", "Number of configs applied since startup, including the initial one")
+	configReloadFailed = metrics.NewGauge("kube_sqs_autoscaler_config_reload_failed", "Whether the last change of the config was rejected")
+)
+
+// configWatcher polls the config file, e.g. a mounted ConfigMap, and sends the
+// config it holds to Run when it changes. Run applies it between polls, so the
+// scaling logic never sees half of a config.
+type configWatcher struct {
+	Path   string
+	Period time.Duration
+	// Config is the config changes are applied to, Command what it is
+	// validated for
+	Config  *Config
+	Command string
+	// Pinned are the flags set on the command line or by environment
+	// variables, which take precedence over the config
+	Pinned map[string]bool
+
+	Reloads chan *Config
+
+	generation int
+	content    []byte
+	initial    *Config
+}
+
+// newConfigWatcher creates a watcher of the file config was loaded from,
+// pinned being the flags not set by it
+func newConfigWatcher(config *Config, command string, pinned map[string]bool) (*configWatcher, error) {
+	content, err := ioutil.ReadFile(config.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	initial := newConfig()
+	if err := parseConfig(content, initial); err != nil {
+		return nil, err
+	}
+
+	configGeneration.Set(1)
+	return &configWatcher{
+		Path:       config.ConfigFile,
+		Period:     config.ConfigReloadPeriod.Duration,
+		Config:     config,
+		Command:    command,
+		Pinned:     pinned,
+		Reloads:    make(chan *Config),
+		generation: 1,
+		content:    content,
+		initial:    initial,
+	}, nil
+}
+
+// pinnedFlags returns the flags set on the command line or by environment
+// variables, before the config is loaded
+func pinnedFlags(flags *flag.FlagSet, environ []string) map[string]bool {
+	pinned := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		pinned[f.Name] = true
+	})
+
+	env := make(map[string]bool)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = true
+		}
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if env[envName(f.Name)] {
+			pinned[f.Name] = true
+		}
+	})
+
+	return pinned
+}
+
+// Watch sends the config whenever its content changes, until
+// done is closed
+func (w *configWatcher) Watch(done <-chan struct{}) {
+	ticker := time.NewTicker(w.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		content, err := ioutil.ReadFile(w.Path)
+		if err != nil {
+			log.Errorf("Failed to read config %s: %v", w.Path, err)
+			continue
+		}
+		if bytes.Equal(content, w.content) {
+			continue
+		}
+		w.content = content
+
+		// fields removed from the file are reset to their defaults
+		config := newConfig()
+		if err := parseConfig(content, config); err != nil {
+			log.Errorf("Rejected config %s: %v", w.Path, err)
+			configReloadFailed.Set(1)
+			continue
+		}
+
+		select {
+		case w.Reloads <- config:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Apply sets the reloadable fields of the config to those of a changed one.
+// Nothing is changed if the result is invalid. Other fields select what is
+// scaled and need a restart.
+func (w *configWatcher) Apply(config *Config) bool {
+	var restart []string
+
+	previous := *w.Config
+	current := w.Config.flags()
+	initial := w.initial.flags()
+	config.flags().VisitAll(func(f *flag.Flag) {
+		if w.Pinned[f.Name] {
+			return
+		}
+		if !reloadableFlags[f.Name] {
+			if f.Value.String() != initial.Lookup(f.Name).Value.String() {
+				restart = append(restart, "--"+f.Name)
+			}
+			return
+		}
+		current.Set(f.Name, f.Value.String())
+	})
+
+	if errs := w.Config.validate(w.Command); len(errs) > 0 {
+		*w.Config = previous
+
+		log.Errorf("Rejected config %s, keeping generation %d:\n%v", w.Path, w.generation, errs)
+		configReloadFailed.Set(1)
+		return false
+	}
+
+	var changes []string
+	previous.flags().VisitAll(func(f *flag.Flag) {
+		if value := current.Lookup(f.Name).Value.String(); value != f.Value.String() {
+			changes = append(changes, fmt.Sprintf("--%s %s -> %s", f.Name, f.Value, value))
+		}
+	})
+
+	w.generation++
+	configGeneration.Set(float64(w.generation))
+	configReloadFailed.Set(0)
+
+	if len(changes) == 0 {
+		changes = []string{"no changes"}
+	}
+	log.Infof("Applied config generation %d: %s", w.generation, strings.Join(changes, ", "))
+
+	if len(restart) > 0 {
+		log.Warnf("Changes to %s need a restart and are ignored", strings.Join(restart, ", "))
+	}
+
+	return true
+}
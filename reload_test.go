@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestConfigWatcher(t *testing.T, pinned map[string]bool) *configWatcher {
+	config := newConfig()
+	config.SqsQueueUrl = "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	config.KubernetesDeploymentName = "test"
+
+	return &configWatcher{
+		Path:       "config.yaml",
+		Config:     config,
+		Pinned:     pinned,
+		generation: 1,
+		initial:    newConfig(),
+	}
+}
+
+// newTestConfig returns the config of a config file
+func newTestConfig(t *testing.T, content string) *Config {
+	config := newConfig()
+	assert.Nil(t, parseConfig([]byte(content), config))
+	return config
+}
+
+func TestConfigWatcherApply(t *testing.T) {
+	w := newTestConfigWatcher(t, map[string]bool{"sqs-queue-url": true})
+
+	assert.True(t, w.Apply(newTestConfig(t, "max-pods: 10\nscale-up-messages: 50\n")))
+	assert.Equal(t, 10, w.Config.MaxPods)
+	assert.Equal(t, 50, w.Config.ScaleUpMessages)
+	assert.Equal(t, 2, w.generation)
+
+	// invalid configs are rejected as a whole
+	assert.False(t, w.Apply(newTestConfig(t, "max-pods: 20\nmin-pods: 30\n")))
+	assert.Equal(t, 10, w.Config.MaxPods)
+	assert.Equal(t, 1, w.Config.MinPods)
+	assert.Equal(t, 2, w.generation)
+
+	// removed fields are reset, fields selecting the queue are not reloaded
+	assert.True(t, w.Apply(newTestConfig(t, "sqs-queue-url: https://sqs.us-east-1.amazonaws.com/123456789012/other\nsource: kafka\n")))
+	assert.Equal(t, 5, w.Config.MaxPods)
+	assert.Equal(t, 100, w.Config.ScaleUpMessages)
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/orders", w.Config.SqsQueueUrl)
+	assert.Equal(t, "sqs", w.Config.QueueSource)
+	assert.Equal(t, 3, w.generation)
+
+	// flags set on the command line or environment take precedence
+	w = newTestConfigWatcher(t, map[string]bool{"max-pods": true})
+	w.Config.MaxPods = 8
+	assert.True(t, w.Apply(newTestConfig(t, "max-pods: 10\nmin-pods: 2\npoll-period: 1m\n")))
+	assert.Equal(t, 8, w.Config.MaxPods)
+	assert.Equal(t, 2, w.Config.MinPods)
+	assert.Equal(t, time.Minute, w.Config.PollInterval.Duration)
+}
+
+func TestConfigWatcherWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("max-pods: 5\n"), 0644))
+
+	config := newConfig()
+	config.ConfigFile = path
+	config.ConfigReloadPeriod.Duration = 10 * time.Millisecond
+	w, err := newConfigWatcher(config, "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, w.initial.MaxPods)
+
+	done := make(chan struct{})
+	defer close(done)
+	go w.Watch(done)
+
+	// editors and config management replace the file by renaming
+	assert.Nil(t, ioutil.WriteFile(path+".new", []byte("max-pods: 10\n"), 0644))
+	assert.Nil(t, os.Rename(path+".new", path))
+
+	select {
+	case changed := <-w.Reloads:
+		assert.Equal(t, 10, changed.MaxPods)
+		assert.Equal(t, 5*time.Second, changed.PollInterval.Duration, "fields not in the config have their defaults")
+	case <-time.After(time.Second):
+		t.Fatal("Config change not detected")
+	}
+
+	// unchanged content is not sent again
+	select {
+	case changed := <-w.Reloads:
+		t.Fatalf("Unexpected reload %v", changed)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfigWatcherConfigMapSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a mounted ConfigMap links each key to ..data, itself a link to the
+	// directory of the current version
+	writeVersion := func(version string, content string) {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, version), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(content), 0644))
+		assert.Nil(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		assert.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	writeVersion("..2024_01_01", "max-pods: 5\n")
+	path := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, os.Symlink(filepath.Join("..data", "config.yaml"), path))
+
+	config := newConfig()
+	config.ConfigFile = path
+	config.ConfigReloadPeriod.Duration = 10 * time.Millisecond
+	w, err := newConfigWatcher(config, "", nil)
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	defer close(done)
+	go w.Watch(done)
+
+	// the kubelet swaps ..data and removes the old version
+	writeVersion("..2024_01_02", "max-pods: 10\n")
+	assert.Nil(t, os.RemoveAll(filepath.Join(dir, "..2024_01_01")))
+
+	select {
+	case changed := <-w.Reloads:
+		assert.Equal(t, 10, changed.MaxPods)
+	case <-time.After(time.Second):
+		t.Fatal("ConfigMap update not detected")
+	}
+}
+
+func TestPinnedFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("max-pods", 5, "")
+	flags.Int("min-pods", 1, "")
+	flags.Int("scale-up-messages", 100, "")
+	flags.Parse([]string{"--max-pods=10"})
+
+	pinned := pinnedFlags(flags, []string{"KUBE_SQS_AUTOSCALER_MIN_PODS=2", "HOME=/root"})
+	assert.Equal(t, map[string]bool{"max-pods": true, "min-pods": true}, pinned)
+}
@@ -0,0 +1,36 @@
+package scale
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConflictingHPAs returns the names of the HorizontalPodAutoscalers scaling
+// the deployment, which would fight with the autoscaler over its replicas
+func (p *PodAutoScaler) ConflictingHPAs() ([]string, error) {
+	hpas, err := p.Client.AutoscalingV1().HorizontalPodAutoscalers(p.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list horizontal pod autoscalers from kube server")
+	}
+
+	var names []string
+	for _, hpa := range hpas.Items {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind != "Deployment" || ref.Name != p.Deployment {
+			continue
+		}
+
+		group := strings.Split(ref.APIVersion, "/")[0]
+		if ref.APIVersion != "" && group != "apps" && group != "extensions" {
+			continue
+		}
+
+		names = append(names, hpa.Name)
+	}
+
+	return names, nil
+}
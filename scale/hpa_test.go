@@ -0,0 +1,45 @@
+package scale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func NewMockHPA(name string, namespace string, apiVersion string, kind string, target string) *autoscalingv1.HorizontalPodAutoscaler {
+	return &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+				APIVersion: apiVersion,
+				Kind:       kind,
+				Name:       target,
+			},
+		},
+	}
+}
+
+func TestConflictingHPAs(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+
+	names, err := p.ConflictingHPAs()
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+
+	p.Client = fake.NewSimpleClientset(
+		NewMockDeployment("test", "test"),
+		NewMockHPA("a", "test", "apps/v1", "Deployment", "test"),
+		NewMockHPA("b", "test", "extensions/v1beta1", "Deployment", "test"),
+		NewMockHPA("c", "test", "apps/v1", "Deployment", "other"),
+		NewMockHPA("d", "test", "apps/v1", "StatefulSet", "test"),
+		NewMockHPA("e", "other", "apps/v1", "Deployment", "test"),
+	)
+
+	names, err = p.ConflictingHPAs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+}
@@ -0,0 +1,97 @@
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManualScaleAnnotation is set on the deployment when its replicas were
+// changed by someone else. The autoscaler backs off while it is present.
+const ManualScaleAnnotation = "kube-sqs-autoscaler/manual-scale"
+
+// FieldManager identifies the autoscaler's changes in managedFields
+const FieldManager = "kube-sqs-autoscaler"
+
+// replicasManager returns the manager that most recently set spec.replicas of
+// the deployment, or an empty string if managedFields do not tell
+func replicasManager(deployment *appsv1.Deployment) string {
+	manager := ""
+	var latest time.Time
+
+	for _, entry := range deployment.ManagedFields {
+		if entry.Manager == FieldManager || entry.FieldsV1 == nil || entry.Time == nil {
+			continue
+		}
+
+		var fields struct {
+			Spec map[string]interface{} `json:"f:spec"`
+		}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields.Spec["f:replicas"]; !ok {
+			continue
+		}
+
+		if entry.Time.After(latest) {
+			manager = entry.Manager
+			latest = entry.Time.Time
+		}
+	}
+
+	return manager
+}
+
+func parseManualScale(annotations map[string]string) time.Time {
+	value, ok := annotations[ManualScaleAnnotation]
+	if !ok {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// an unreadable annotation still means the operator wants us to back off
+		return time.Unix(0, 0)
+	}
+	return t
+}
+
+// MarkManualScale annotates the deployment as manually scaled at t
+func (p *PodAutoScaler) MarkManualScale(t time.Time) error {
+	return p.annotate(ManualScaleAnnotation, t.UTC().Format(time.RFC3339))
+}
+
+// ClearManualScale removes the manual scale annotation from the deployment
+func (p *PodAutoScaler) ClearManualScale() error {
+	return p.annotate(ManualScaleAnnotation, "")
+}
+
+// annotate sets an annotation on the deployment, or removes it if value is empty
+func (p *PodAutoScaler) annotate(key string, value string) error {
+	deployment, err := p.Client.AppsV1().Deployments(p.Namespace).Get(context.TODO(), p.Deployment, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to get deployment from kube server")
+	}
+
+	if value == "" {
+		delete(deployment.Annotations, key)
+	} else {
+		if deployment.Annotations == nil {
+			deployment.Annotations = make(map[string]string)
+		}
+		deployment.Annotations[key] = value
+	}
+
+	_, err = p.Client.AppsV1().Deployments(p.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{FieldManager: FieldManager})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to annotate deployment with %s", key)
+	}
+
+	return nil
+}
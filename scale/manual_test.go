@@ -0,0 +1,60 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReplicasManager(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	assert.Equal(t, "", replicasManager(deployment))
+
+	earlier := metav1.NewTime(time.Now().Add(-time.Hour))
+	later := metav1.NewTime(time.Now())
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{
+		{Manager: "kubectl", Time: &earlier, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)}},
+		{Manager: "helm", Time: &later, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:template":{}}}`)}},
+		{Manager: FieldManager, Time: &later, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)}},
+	}
+	assert.Equal(t, "kubectl", replicasManager(deployment))
+}
+
+func TestManualScale(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	status, err := p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.True(t, status.ManualScaleTime.IsZero())
+
+	assert.Nil(t, p.MarkManualScale(now))
+	status, err = p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(status.ManualScaleTime))
+
+	assert.Nil(t, p.ClearManualScale())
+	status, err = p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.True(t, status.ManualScaleTime.IsZero())
+}
+
+func TestManualScaleInvalidAnnotation(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	deployment.Annotations = map[string]string{ManualScaleAnnotation: "yes"}
+
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	p.Client = fake.NewSimpleClientset(deployment)
+
+	status, err := p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.False(t, status.ManualScaleTime.IsZero())
+
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, "yes", deployment.Annotations[ManualScaleAnnotation])
+}
@@ -0,0 +1,122 @@
+package scale
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Annotations on the deployment that override the autoscaler's configuration
+const (
+	PausedAnnotation        = "kube-sqs-autoscaler/paused"
+	MinReplicasAnnotation   = "kube-sqs-autoscaler/min-replicas"
+	MaxReplicasAnnotation   = "kube-sqs-autoscaler/max-replicas"
+	OverrideUntilAnnotation = "kube-sqs-autoscaler/override-until"
+)
+
+// Override is set by annotating the deployment, e.g. to freeze autoscaling
+// during an incident without restarting the autoscaler
+type Override struct {
+	Paused bool
+	Min    *int32
+	Max    *int32
+
+	// Until is when the override expires, the zero time never expires
+	Until time.Time
+}
+
+// ParseOverride returns the override set by the annotations, or nil if there
+// is none or it has expired. Invalid annotations are ignored and returned as
+// an error along with the override from the valid ones.
+func ParseOverride(annotations map[string]string, now time.Time) (*Override, error) {
+	o := &Override{}
+	var errs []error
+
+	if value, ok := annotations[OverrideUntilAnnotation]; ok {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be an RFC3339 time: %v", OverrideUntilAnnotation, err))
+		} else if !now.Before(until) {
+			return nil, nil
+		} else {
+			o.Until = until
+		}
+	}
+
+	if value, ok := annotations[PausedAnnotation]; ok {
+		paused, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be true or false: %v", PausedAnnotation, err))
+		}
+		o.Paused = paused
+	}
+
+	parseReplicas := func(annotation string) *int32 {
+		value, ok := annotations[annotation]
+		if !ok {
+			return nil
+		}
+
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || replicas < 0 {
+			errs = append(errs, fmt.Errorf("%s must be a non-negative number, got %q", annotation, value))
+			return nil
+		}
+		return int32Ptr(int32(replicas))
+	}
+	o.Min = parseReplicas(MinReplicasAnnotation)
+	o.Max = parseReplicas(MaxReplicasAnnotation)
+
+	if o.Min != nil && o.Max != nil && *o.Min > *o.Max {
+		errs = append(errs, fmt.Errorf("%s (%d) is greater than %s (%d)", MinReplicasAnnotation, *o.Min, MaxReplicasAnnotation, *o.Max))
+		o.Min, o.Max = nil, nil
+	}
+
+	if !o.Paused && o.Min == nil && o.Max == nil {
+		o = nil
+	}
+	return o, utilerrors.NewAggregate(errs)
+}
+
+func (o *Override) String() string {
+	if o == nil {
+		return "none"
+	}
+
+	var parts []string
+	if o.Paused {
+		parts = append(parts, "paused")
+	}
+	if o.Min != nil {
+		parts = append(parts, fmt.Sprintf("min-replicas=%d", *o.Min))
+	}
+	if o.Max != nil {
+		parts = append(parts, fmt.Sprintf("max-replicas=%d", *o.Max))
+	}
+	if !o.Until.IsZero() {
+		parts = append(parts, "until "+o.Until.Format(time.RFC3339))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Override returns the override read by the last GetPodStatus
+func (p *PodAutoScaler) Override() *Override {
+	return p.override
+}
+
+// Bounds returns the min and max pods, taking the override into account
+func (p *PodAutoScaler) Bounds() (int32, int32) {
+	min, max := int32(p.Min), int32(p.Max)
+	if p.override != nil {
+		if p.override.Min != nil {
+			min = *p.override.Min
+		}
+		if p.override.Max != nil {
+			max = *p.override.Max
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,68 @@
+package scale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseOverride(t *testing.T) {
+	now := time.Now()
+
+	o, err := ParseOverride(map[string]string{}, now)
+	assert.Nil(t, err)
+	assert.Nil(t, o)
+
+	o, err = ParseOverride(map[string]string{
+		PausedAnnotation:        "true",
+		MinReplicasAnnotation:   "2",
+		OverrideUntilAnnotation: now.Add(time.Hour).Format(time.RFC3339),
+	}, now)
+	assert.Nil(t, err)
+	assert.True(t, o.Paused)
+	assert.Equal(t, int32(2), *o.Min)
+	assert.Nil(t, o.Max)
+	assert.Equal(t, "paused, min-replicas=2, until "+now.Add(time.Hour).Format(time.RFC3339), o.String())
+
+	// expired overrides are ignored
+	o, err = ParseOverride(map[string]string{
+		PausedAnnotation:        "true",
+		OverrideUntilAnnotation: now.Add(-time.Hour).Format(time.RFC3339),
+	}, now)
+	assert.Nil(t, err)
+	assert.Nil(t, o)
+
+	// invalid annotations are reported and ignored
+	o, err = ParseOverride(map[string]string{
+		PausedAnnotation:      "yes please",
+		MinReplicasAnnotation: "-1",
+		MaxReplicasAnnotation: "3",
+	}, now)
+	assert.NotNil(t, err)
+	assert.False(t, o.Paused)
+	assert.Nil(t, o.Min)
+	assert.Equal(t, int32(3), *o.Max)
+}
+
+func TestScaleOverride(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	deployment.Annotations = map[string]string{
+		MinReplicasAnnotation: "2",
+		MaxReplicasAnnotation: "8",
+	}
+
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	p.Client = fake.NewSimpleClientset(deployment)
+
+	_, err := p.GetPodStatus()
+	assert.Nil(t, err)
+
+	min, max := p.Bounds()
+	assert.Equal(t, int32(2), min)
+	assert.Equal(t, int32(8), max)
+	assert.Equal(t, int32(8), p.Clamp(10))
+	assert.Equal(t, int32(2), p.Clamp(1))
+}
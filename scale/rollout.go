@@ -0,0 +1,35 @@
+package scale
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isRollingOut returns whether a rolling update of the deployment is in
+// progress. A rollout that exceeded its progress deadline is not waited for.
+// scaled is the generation of the last change of replicas by the autoscaler,
+// which is not a rollout.
+func isRollingOut(deployment *appsv1.Deployment, scaled int64) bool {
+	// until the controller has created the pods of its own change of replicas,
+	// only old pods still being replaced tell a rollout
+	if scaled != 0 && deployment.Generation == scaled {
+		return deployment.Status.Replicas > deployment.Status.UpdatedReplicas
+	}
+
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return true
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+			return false
+		}
+	}
+
+	if deployment.Spec.Replicas != nil && deployment.Status.UpdatedReplicas < *deployment.Spec.Replicas {
+		return true
+	}
+
+	// old pods are still being replaced
+	return deployment.Status.Replicas > deployment.Status.UpdatedReplicas
+}
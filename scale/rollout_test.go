@@ -0,0 +1,56 @@
+package scale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestIsRollingOut(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	assert.False(t, isRollingOut(deployment, 0))
+
+	// not yet observed by the deployment controller
+	deployment.Generation = 2
+	deployment.Status.ObservedGeneration = 1
+	assert.True(t, isRollingOut(deployment, 0))
+
+	deployment.Status.ObservedGeneration = 2
+	deployment.Status.UpdatedReplicas = 1
+	deployment.Status.Replicas = 4
+	assert.True(t, isRollingOut(deployment, 0))
+
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+	}
+	assert.False(t, isRollingOut(deployment, 0))
+}
+
+func TestIsRollingOutOwnScale(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	deployment.Spec.Replicas = int32Ptr(5)
+	deployment.Status.Replicas = 3
+	deployment.Status.UpdatedReplicas = 3
+
+	// scaled up by the autoscaler, not yet observed by the deployment controller
+	deployment.Generation = 2
+	deployment.Status.ObservedGeneration = 1
+	assert.True(t, isRollingOut(deployment, 0))
+	assert.False(t, isRollingOut(deployment, 2))
+
+	// observed, while the new pods are created
+	deployment.Status.ObservedGeneration = 2
+	assert.False(t, isRollingOut(deployment, 2))
+
+	// old pods are still being replaced
+	deployment.Status.Replicas = 4
+	assert.True(t, isRollingOut(deployment, 2))
+
+	// the template changed since
+	deployment.Status.Replicas = 3
+	deployment.Generation = 3
+	assert.True(t, isRollingOut(deployment, 2))
+}
@@ -2,69 +2,206 @@ package scale
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 
-	restclient "k8s.io/client-go/rest"
-	kclient "k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 type PodAutoScaler struct {
-	Client     *kclient.Clientset
+	Client     kclient.Interface
+	Recorder   record.EventRecorder
 	Max        int
 	Min        int
 	Deployment string
 	Namespace  string
+
+	startupLatency time.Duration
+	starting       map[types.UID]bool
+	lastObserved   time.Time
+	override       *Override
+	// scaledGeneration is the generation of the deployment after the last scale
+	scaledGeneration int64
 }
 
 func NewPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max int, min int) *PodAutoScaler {
+	p, err := NewInClusterPodAutoScaler(kubernetesDeploymentName, kubernetesNamespace, max, min)
+	if err != nil {
+		panic(err.Error())
+	}
+	return p
+}
+
+// NewInClusterPodAutoScaler is NewPodAutoScaler returning an error instead of
+// panicking when not running in a cluster
+func NewInClusterPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max int, min int) (*PodAutoScaler, error) {
 	config, err := restclient.InClusterConfig()
 	if err != nil {
-		panic("Failed to configure incluster config")
+		return nil, errors.Wrap(err, "Failed to configure incluster config")
 	}
 
 	k8sClient, err := kclient.NewForConfig(config)
 	if err != nil {
-		panic("Failed to configure client")
+		return nil, errors.Wrap(err, "Failed to configure client")
 	}
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events(kubernetesNamespace)})
+
 	return &PodAutoScaler{
 		Client:     k8sClient,
+		Recorder:   broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kube-sqs-autoscaler"}),
 		Min:        min,
 		Max:        max,
 		Deployment: kubernetesDeploymentName,
 		Namespace:  kubernetesNamespace,
+	}, nil
+}
+
+// PodStatus holds the replica counts of the deployment being scaled
+type PodStatus struct {
+	Spec        int32
+	Ready       int32
+	Available   int32
+	Unavailable int32
+
+	// Unschedulable is the number of pods the scheduler could not place
+	Unschedulable int32
+
+	// OverrideError reports invalid override annotations, which are ignored
+	OverrideError error
+
+	// ManualScaleTime is when replicas were found changed by someone else,
+	// the zero time if the autoscaler is not backing off
+	ManualScaleTime time.Time
+
+	// ReplicasManager is who last changed the replicas according to managedFields
+	ReplicasManager string
+
+	// RollingOut is whether a rolling update of the deployment is in progress
+	RollingOut bool
+}
+
+// Pending returns the number of requested pods that are not available yet
+func (s *PodStatus) Pending() int32 {
+	if s.Spec > s.Available {
+		return s.Spec - s.Available
+	}
+	return 0
+}
+
+// Schedulable returns the number of requested pods that could be scheduled
+func (s *PodStatus) Schedulable() int32 {
+	if s.Spec > s.Unschedulable {
+		return s.Spec - s.Unschedulable
 	}
+	return 0
 }
 
 func int32Ptr(x int32) *int32 {
 	return &x
 }
 
-func (p *PodAutoScaler) GetPods() (int32, error) {
-	deployment, err := p.Client.AppsV1beta2().Deployments(p.Namespace).Get(context.TODO(), p.Deployment, metav1.GetOptions{})
+func (p *PodAutoScaler) GetPodStatus() (*PodStatus, error) {
+	deployment, err := p.Client.AppsV1().Deployments(p.Namespace).Get(context.TODO(), p.Deployment, metav1.GetOptions{})
 	if err != nil {
-		return 0, errors.Wrap(err, "Failed to get deployment from kube server")
+		return nil, errors.Wrap(err, "Failed to get deployment from kube server")
+	}
+
+	status := &PodStatus{
+		Spec:        deployment.Status.Replicas,
+		Ready:       deployment.Status.ReadyReplicas,
+		Available:   deployment.Status.AvailableReplicas,
+		Unavailable: deployment.Status.UnavailableReplicas,
+	}
+	if deployment.Spec.Replicas != nil {
+		status.Spec = *deployment.Spec.Replicas
+	}
+
+	p.override, status.OverrideError = ParseOverride(deployment.Annotations, time.Now())
+	status.ManualScaleTime = parseManualScale(deployment.Annotations)
+	status.ReplicasManager = replicasManager(deployment)
+	status.RollingOut = isRollingOut(deployment, p.scaledGeneration)
+
+	if deployment.Spec.Selector != nil {
+		pods, err := p.Client.CoreV1().Pods(p.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: metav1.FormatLabelSelector(deployment.Spec.Selector),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to list pods from kube server")
+		}
+
+		for _, pod := range pods.Items {
+			if isUnschedulable(&pod) {
+				status.Unschedulable++
+			}
+		}
+
+		p.observeStartup(pods.Items, time.Now())
 	}
 
-	return deployment.Status.AvailableReplicas, nil
+	return status, nil
 }
 
-func (p *PodAutoScaler) Scale(numPods int32) error {
+func isUnschedulable(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodPending {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
+
+// Eventf records an event on the deployment being scaled
+func (p *PodAutoScaler) Eventf(eventType string, reason string, messageFmt string, args ...interface{}) {
+	if p.Recorder == nil {
+		return
+	}
+
+	ref := &corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       p.Deployment,
+		Namespace:  p.Namespace,
+	}
+	p.Recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// Clamp returns the number of pods Scale would set for numPods
+func (p *PodAutoScaler) Clamp(numPods int32) int32 {
+	min, max := p.Bounds()
+
 	if numPods < 0 {
-		numPods = int32(p.Min)
+		numPods = min
 	}
 
-	if numPods >= int32(p.Max) {
-		numPods = int32(p.Max)
+	if numPods >= max {
+		numPods = max
 	}
-	if numPods <= int32(p.Min) {
-		numPods = int32(p.Min)
+	if numPods <= min {
+		numPods = min
 	}
 
+	return numPods
+}
+
+func (p *PodAutoScaler) Scale(numPods int32) error {
+	numPods = p.Clamp(numPods)
+
 	deployment, err := p.Client.AppsV1().Deployments(p.Namespace).Get(context.TODO(), p.Deployment, metav1.GetOptions{})
 	if err != nil {
 		return errors.Wrap(err, "Failed to get deployment from kube server, no scale up occured")
@@ -72,10 +209,11 @@ func (p *PodAutoScaler) Scale(numPods int32) error {
 
 	deployment.Spec.Replicas = int32Ptr(numPods)
 
-	_, err = p.Client.AppsV1().Deployments(p.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	updated, err := p.Client.AppsV1().Deployments(p.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{FieldManager: FieldManager})
 	if err != nil {
 		return errors.Wrap(err, "Failed to scale")
 	}
+	p.scaledGeneration = updated.Generation
 
 	log.Infof("Scale successful. Replicas: %d", *deployment.Spec.Replicas)
 	return nil
@@ -1,113 +1,140 @@
 package scale
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
 	"testing"
 
-	"k8s.io/api"
-	extensions "k8s.io/api/extensions/v1beta1"
-	kclient "k8s.io/client-go/kubernetes"
-	"k8s.io/apimachinery/pkg/watch"
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestScaleUp(t *testing.T) {
 	p := NewMockPodAutoScaler("test", "test", 5, 1)
 
 	// Scale up replicas until we reach the max (5).
-	// Scale up again and assert that we get an error back when trying to scale up replicas pass the max
-	err := p.ScaleUp()
-	deployment, _ := p.Client.Deployments("test").Get("test")
+	// Scale up again and assert that replicas are capped at the max
+	err := p.Scale(4)
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+	err = p.Scale(5)
 	assert.Nil(t, err)
-	assert.Equal(t, int32(4), deployment.Spec.Replicas)
-	err = p.ScaleUp()
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(5), *deployment.Spec.Replicas)
+
+	err = p.Scale(6)
 	assert.Nil(t, err)
-	deployment, _ = p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(5), deployment.Spec.Replicas)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(5), *deployment.Spec.Replicas)
+}
 
-	err = p.ScaleUp()
+func TestNewInClusterPodAutoScaler(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	// outside a cluster there is an error instead of a panic
+	_, err := NewInClusterPodAutoScaler("test", "test", 5, 1)
 	assert.NotNil(t, err)
-	deployment, _ = p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(5), deployment.Spec.Replicas)
 }
 
 func TestScaleDown(t *testing.T) {
 	p := NewMockPodAutoScaler("test", "test", 5, 1)
 
-	err := p.ScaleDown()
+	err := p.Scale(2)
 	assert.Nil(t, err)
-	deployment, _ := p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(2), deployment.Spec.Replicas)
-	err = p.ScaleDown()
+	deployment, _ := p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	err = p.Scale(1)
 	assert.Nil(t, err)
-	deployment, _ = p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(1), deployment.Spec.Replicas)
-
-	err = p.ScaleDown()
-	assert.NotNil(t, err)
-	deployment, _ = p.Client.Deployments("test").Get("test")
-	assert.Equal(t, int32(1), deployment.Spec.Replicas)
-}
-
-type MockDeployment struct {
-	client *MockKubeClient
-}
-
-type MockKubeClient struct {
-	// stores the state of Deployment as if the api server did
-	Deployment *extensions.Deployment
-}
-
-func (m *MockDeployment) Get(name string) (*extensions.Deployment, error) {
-	return m.client.Deployment, nil
-}
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
 
-func (m *MockDeployment) Update(deployment *extensions.Deployment) (*extensions.Deployment, error) {
-	m.client.Deployment.Spec.Replicas = deployment.Spec.Replicas
-	return m.client.Deployment, nil
+	err = p.Scale(0)
+	assert.Nil(t, err)
+	deployment, _ = p.Client.AppsV1().Deployments("test").Get(context.TODO(), "test", metav1.GetOptions{})
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
 }
 
-func (m *MockDeployment) List(opts api.ListOptions) (*extensions.DeploymentList, error) {
-	return nil, nil
-}
+func TestGetPodStatus(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	deployment.Spec.Replicas = int32Ptr(5)
+	deployment.Status.UpdatedReplicas = 5
+	deployment.Status.ReadyReplicas = 3
+	deployment.Status.UnavailableReplicas = 2
 
-func (m *MockDeployment) Delete(name string, options *api.DeleteOptions) error {
-	return nil
-}
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	p.Client = fake.NewSimpleClientset(deployment)
 
-func (m *MockDeployment) Create(*extensions.Deployment) (*extensions.Deployment, error) {
-	return nil, nil
+	status, err := p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, &PodStatus{Spec: 5, Ready: 3, Available: 3, Unavailable: 2}, status)
+	assert.Equal(t, int32(2), status.Pending())
 }
 
-func (m *MockDeployment) UpdateStatus(*extensions.Deployment) (*extensions.Deployment, error) {
-	return nil, nil
-}
+func TestGetPodStatusUnschedulable(t *testing.T) {
+	deployment := NewMockDeployment("test", "test")
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}
+
+	pod := func(name string, app string, reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Labels: map[string]string{"app": app}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: reason},
+				},
+			},
+		}
+	}
 
-func (m *MockDeployment) Watch(opts api.ListOptions) (watch.Interface, error) {
-	return nil, nil
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	p.Client = fake.NewSimpleClientset(
+		deployment,
+		pod("a", "test", corev1.PodReasonUnschedulable),
+		pod("b", "test", corev1.PodReasonUnschedulable),
+		pod("c", "test", ""),
+		pod("d", "other", corev1.PodReasonUnschedulable),
+	)
+
+	status, err := p.GetPodStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), status.Unschedulable)
+	assert.Equal(t, int32(1), status.Schedulable())
 }
 
-func (m *MockDeployment) Rollback(*extensions.DeploymentRollback) error {
-	return nil
-}
+func TestEventf(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	recorder := record.NewFakeRecorder(1)
+	p.Recorder = recorder
 
-func (m *MockKubeClient) Deployments(namespace string) kclient.DeploymentInterface {
-	return &MockDeployment{
-		client: m,
-	}
+	p.Eventf(corev1.EventTypeWarning, "ClusterFull", "%d pods cannot be scheduled", 2)
+	assert.Equal(t, "Warning ClusterFull 2 pods cannot be scheduled", <-recorder.Events)
 }
 
-func NewMockKubeClient() *MockKubeClient {
-	return &MockKubeClient{
-		Deployment: &extensions.Deployment{
-			Spec: extensions.DeploymentSpec{
-				Replicas: 3,
-			},
+func NewMockDeployment(kubernetesDeploymentName string, kubernetesNamespace string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesDeploymentName,
+			Namespace: kubernetesNamespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(3),
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          3,
+			UpdatedReplicas:   3,
+			ReadyReplicas:     3,
+			AvailableReplicas: 3,
 		},
 	}
 }
 
 func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max int, min int) *PodAutoScaler {
-	mockClient := NewMockKubeClient()
+	mockClient := fake.NewSimpleClientset(NewMockDeployment(kubernetesDeploymentName, kubernetesNamespace))
 
 	return &PodAutoScaler{
 		Client:     mockClient,
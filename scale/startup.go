@@ -0,0 +1,81 @@
+package scale
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// startupWeight is the weight of a new sample in the rolling startup latency
+const startupWeight = 0.3
+
+// maxStartupLatency caps the measured startup latency. Pods not ready by then
+// are likely failing rather than starting, and must not skew the estimate.
+const maxStartupLatency = 30 * time.Minute
+
+// creationSlack allows for creation timestamps being truncated to seconds
+const creationSlack = time.Second
+
+// StartupLatency returns the rolling estimate of the time pods take from being
+// created to becoming ready
+func (p *PodAutoScaler) StartupLatency() time.Duration {
+	return p.startupLatency
+}
+
+// SetStartupLatency sets the startup latency estimate, e.g. to seed it before
+// any pod was observed starting
+func (p *PodAutoScaler) SetStartupLatency(latency time.Duration) {
+	p.startupLatency = latency
+}
+
+// observeStartup updates the startup latency estimate from the pods of the
+// deployment listed at now. Only pods that were seen starting shortly after
+// being created are measured, as older pods may have become unready and ready
+// again long after they started.
+func (p *PodAutoScaler) observeStartup(pods []corev1.Pod, now time.Time) {
+	starting := make(map[types.UID]bool)
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		created := pod.CreationTimestamp.Time
+		ready := readyCondition(&pod)
+		if ready == nil || ready.Status != corev1.ConditionTrue {
+			isNew := created.After(p.lastObserved.Add(-creationSlack)) && now.Sub(created) < maxStartupLatency
+			if p.starting[pod.UID] || isNew {
+				starting[pod.UID] = true
+			}
+			continue
+		}
+
+		if !p.starting[pod.UID] {
+			continue
+		}
+
+		latency := ready.LastTransitionTime.Sub(created)
+		if latency < 0 || latency > maxStartupLatency {
+			continue
+		}
+
+		if p.startupLatency == 0 {
+			p.startupLatency = latency
+		} else {
+			p.startupLatency = time.Duration(startupWeight*float64(latency) + (1-startupWeight)*float64(p.startupLatency))
+		}
+	}
+
+	p.starting = starting
+	p.lastObserved = now
+}
+
+func readyCondition(pod *corev1.Pod) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == corev1.PodReady {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package scale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func NewMockStartingPod(uid string, created time.Time, ready *time.Time) corev1.Pod {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:               types.UID(uid),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+
+	if ready != nil {
+		pod.Status.Conditions = []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(*ready)},
+		}
+	}
+	return pod
+}
+
+func TestObserveStartup(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	now := time.Now()
+	aReady := now.Add(90 * time.Second)
+	bReady := now.Add(40 * time.Second)
+
+	// pods that were already ready are not measured
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("old", now, &aReady)}, now)
+	assert.Equal(t, time.Duration(0), p.StartupLatency())
+
+	p.observeStartup([]corev1.Pod{
+		NewMockStartingPod("old", now, &aReady),
+		NewMockStartingPod("a", now, nil),
+		NewMockStartingPod("b", now, nil),
+	}, now.Add(10*time.Second))
+	assert.Equal(t, time.Duration(0), p.StartupLatency())
+
+	p.observeStartup([]corev1.Pod{
+		NewMockStartingPod("old", now, &aReady),
+		NewMockStartingPod("a", now, &aReady),
+		NewMockStartingPod("b", now, nil),
+	}, now.Add(100*time.Second))
+	assert.Equal(t, 90*time.Second, p.StartupLatency())
+
+	p.observeStartup([]corev1.Pod{
+		NewMockStartingPod("old", now, &aReady),
+		NewMockStartingPod("a", now, &aReady),
+		NewMockStartingPod("b", now, &bReady),
+	}, now.Add(110*time.Second))
+	assert.Equal(t, 75*time.Second, p.StartupLatency())
+
+	// measured pods are not counted again
+	p.observeStartup([]corev1.Pod{
+		NewMockStartingPod("a", now, &aReady),
+		NewMockStartingPod("b", now, &bReady),
+	}, now.Add(120*time.Second))
+	assert.Equal(t, 75*time.Second, p.StartupLatency())
+}
+
+func TestObserveStartupIgnoresOldPods(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	created := time.Now().Add(-72 * time.Hour)
+	now := time.Now()
+	ready := now.Add(10 * time.Second)
+
+	// a pod whose readiness flaps days after it was created did not start
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("old", created, &ready)}, now)
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("old", created, nil)}, now.Add(5*time.Second))
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("old", created, &ready)}, now.Add(15*time.Second))
+	assert.Equal(t, time.Duration(0), p.StartupLatency())
+
+	// nor is a new pod that took hours to become ready
+	stuckCreated := now.Add(18 * time.Second)
+	stuckReady := stuckCreated.Add(2 * time.Hour)
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("stuck", stuckCreated, nil)}, now.Add(20*time.Second))
+	assert.True(t, p.starting["stuck"])
+	p.observeStartup([]corev1.Pod{NewMockStartingPod("stuck", stuckCreated, &stuckReady)}, stuckReady)
+	assert.Equal(t, time.Duration(0), p.StartupLatency())
+}
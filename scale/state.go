@@ -0,0 +1,121 @@
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxDecisions is the number of recent decisions kept in the state
+	maxDecisions = 20
+
+	stateKey = "state.json"
+)
+
+// State is what the autoscaler learned about the deployment, saved so a
+// restarted autoscaler carries on where the previous one stopped
+type State struct {
+	PodRate float64 `json:"podRate"`
+	// LastReplicas is the replica count the autoscaler last set
+	LastReplicas      int32         `json:"lastReplicas"`
+	StartupLatency    time.Duration `json:"startupLatency"`
+	LastScaleUpTime   time.Time     `json:"lastScaleUpTime"`
+	LastScaleDownTime time.Time     `json:"lastScaleDownTime"`
+	Decisions         []Decision    `json:"decisions,omitempty"`
+}
+
+// Decision is a scaling decision taken by the autoscaler
+type Decision struct {
+	Time   time.Time `json:"time"`
+	From   int32     `json:"from"`
+	To     int32     `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// Record adds a decision to the state, dropping the oldest ones
+func (s *State) Record(from int32, to int32, reason string) {
+	s.Decisions = append(s.Decisions, Decision{
+		Time:   time.Now(),
+		From:   from,
+		To:     to,
+		Reason: reason,
+	})
+
+	if len(s.Decisions) > maxDecisions {
+		s.Decisions = s.Decisions[len(s.Decisions)-maxDecisions:]
+	}
+}
+
+// StateConfigMap returns the name of the ConfigMap the state is saved to
+func (p *PodAutoScaler) StateConfigMap() string {
+	return "kube-sqs-autoscaler-" + p.Deployment
+}
+
+// LoadState returns the saved state, or an empty state if none was saved
+func (p *PodAutoScaler) LoadState() (*State, error) {
+	state := &State{}
+
+	configMap, err := p.Client.CoreV1().ConfigMaps(p.Namespace).Get(context.TODO(), p.StateConfigMap(), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get state from kube server")
+	}
+
+	if err := json.Unmarshal([]byte(configMap.Data[stateKey]), state); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse state")
+	}
+
+	return state, nil
+}
+
+// SaveState saves the state, creating the ConfigMap if needed
+func (p *PodAutoScaler) SaveState(state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode state")
+	}
+
+	configMaps := p.Client.CoreV1().ConfigMaps(p.Namespace)
+
+	configMap, err := configMaps.Get(context.TODO(), p.StateConfigMap(), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.StateConfigMap(),
+				Namespace: p.Namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "kube-sqs-autoscaler"},
+			},
+			Data: map[string]string{stateKey: string(data)},
+		}
+
+		_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrap(err, "Failed to create state")
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to get state from kube server")
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[stateKey] = string(data)
+
+	_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to save state")
+	}
+
+	return nil
+}
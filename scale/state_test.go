@@ -0,0 +1,53 @@
+package scale
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStateMissing(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+
+	state, err := p.LoadState()
+	assert.Nil(t, err)
+	assert.Equal(t, &State{}, state)
+}
+
+func TestSaveState(t *testing.T) {
+	p := NewMockPodAutoScaler("test", "test", 5, 1)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	state := &State{
+		PodRate:           12.5,
+		StartupLatency:    90 * time.Second,
+		LastScaleUpTime:   now,
+		LastScaleDownTime: now,
+	}
+	state.Record(3, 4, "100 messages in queue")
+
+	// saving twice creates then updates the ConfigMap
+	assert.Nil(t, p.SaveState(state))
+	state.PodRate = 15
+	assert.Nil(t, p.SaveState(state))
+
+	loaded, err := p.LoadState()
+	assert.Nil(t, err)
+	assert.Equal(t, 15.0, loaded.PodRate)
+	assert.Equal(t, 90*time.Second, loaded.StartupLatency)
+	assert.True(t, now.Equal(loaded.LastScaleUpTime))
+	assert.Len(t, loaded.Decisions, 1)
+	assert.Equal(t, int32(4), loaded.Decisions[0].To)
+}
+
+func TestRecord(t *testing.T) {
+	state := &State{}
+	for i := 0; i < maxDecisions+5; i++ {
+		state.Record(int32(i), int32(i+1), fmt.Sprintf("decision %d", i))
+	}
+
+	assert.Len(t, state.Decisions, maxDecisions)
+	assert.Equal(t, "decision 5", state.Decisions[0].Reason)
+}
@@ -0,0 +1,82 @@
+package source
+
+import (
+	"sync"
+	"time"
+)
+
+// RateWindow is how far back counters are compared to compute rates
+const RateWindow = time.Minute
+
+// Sample is the value of a queue's counters at a point in time
+type Sample struct {
+	Time time.Time
+	// Produced is the number of messages ever sent to the queue
+	Produced int64
+	// Consumed is the number of messages ever processed from the queue
+	Consumed int64
+}
+
+// Rates computes rates per minute from successive samples of counters, for
+// queues that do not report rates themselves
+type Rates struct {
+	mu      sync.Mutex
+	samples []Sample
+	length  int64
+}
+
+// Add records a sample
+func (r *Rates) Add(s Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(s)
+}
+
+// AddLength records the length of a queue that does not count the messages
+// sent and processed. Only the net change of the length is known, so growth is
+// counted as produced and shrinking as consumed.
+func (r *Rates) AddLength(t time.Time, length int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Sample{Time: t}
+	if len(r.samples) > 0 {
+		last := r.samples[len(r.samples)-1]
+		s.Produced, s.Consumed = last.Produced, last.Consumed
+		if change := length - r.length; change > 0 {
+			s.Produced += change
+		} else {
+			s.Consumed -= change
+		}
+	}
+
+	r.length = length
+	r.add(s)
+}
+
+func (r *Rates) add(s Sample) {
+	r.samples = append(r.samples, s)
+
+	// keep the newest sample older than the window as the base of the rates
+	for len(r.samples) > 2 && s.Time.Sub(r.samples[1].Time) >= RateWindow {
+		r.samples = r.samples[1:]
+	}
+}
+
+// PerMinute returns the messages produced and consumed per minute since the
+// oldest sample in the window, or 0 before there are two samples
+func (r *Rates) PerMinute() (float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.samples) < 2 {
+		return 0, 0
+	}
+
+	base, last := r.samples[0], r.samples[len(r.samples)-1]
+	minutes := last.Time.Sub(base.Time).Minutes()
+	if minutes <= 0 {
+		return 0, 0
+	}
+	return float64(last.Produced-base.Produced) / minutes, float64(last.Consumed-base.Consumed) / minutes
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRates(t *testing.T) {
+	r := &Rates{}
+	now := time.Now()
+
+	r.Add(Sample{Time: now.Add(-30 * time.Second), Produced: 100, Consumed: 100})
+	produced, consumed := r.PerMinute()
+	assert.Equal(t, 0.0, produced)
+	assert.Equal(t, 0.0, consumed)
+
+	r.Add(Sample{Time: now, Produced: 160, Consumed: 130})
+	produced, consumed = r.PerMinute()
+	assert.Equal(t, 120.0, produced)
+	assert.Equal(t, 60.0, consumed)
+}
+
+func TestRatesWindow(t *testing.T) {
+	r := &Rates{}
+	now := time.Now()
+
+	r.Add(Sample{Time: now.Add(-3 * time.Minute), Produced: 0})
+	r.Add(Sample{Time: now.Add(-2 * time.Minute), Produced: 1000})
+	r.Add(Sample{Time: now.Add(-30 * time.Second), Produced: 1100})
+	r.Add(Sample{Time: now, Produced: 1200})
+
+	// the sample of 3 minutes ago is out of the window
+	assert.Equal(t, 3, len(r.samples))
+	produced, _ := r.PerMinute()
+	assert.Equal(t, 100.0, produced)
+}
+
+func TestRatesLength(t *testing.T) {
+	r := &Rates{}
+	now := time.Now()
+
+	r.AddLength(now.Add(-40*time.Second), 50)
+	r.AddLength(now.Add(-20*time.Second), 70)
+	r.AddLength(now, 60)
+
+	produced, consumed := r.PerMinute()
+	assert.Equal(t, 30.0, produced)
+	assert.Equal(t, 15.0, consumed)
+}
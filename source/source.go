@@ -0,0 +1,57 @@
+package source
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/pkg/errors"
+
+	"github.com/hspitzlerc/kube-sqs-autoscaler/cloudwatch"
+	"github.com/hspitzlerc/kube-sqs-autoscaler/sqs"
+)
+
+// Source is a queue the autoscaler scales on. Rates are per minute, ages in
+// seconds.
+type Source interface {
+	// NumMessages returns the number of messages waiting to be received
+	NumMessages() (int, error)
+	// NumInFlight returns the number of messages received but not yet deleted
+	NumInFlight() (int, error)
+	// Age returns the age of the oldest message
+	Age() (float64, error)
+	// NumSent returns the rate messages are sent to the queue at
+	NumSent() (float64, error)
+	// NumDeleted returns the rate messages are processed at
+	NumDeleted() (float64, error)
+}
+
+// SQS is an SQS queue, with its rates and age read from CloudWatch
+type SQS struct {
+	*sqs.SqsClient
+	*cloudwatch.CloudWatchClient
+}
+
+func NewSQS(queueUrl string, queueName string, p client.ConfigProvider, sqsConfig *aws.Config, cloudwatchConfig *aws.Config) *SQS {
+	return &SQS{
+		sqs.NewSqsClient(queueUrl, p, sqsConfig),
+		cloudwatch.NewCloudWatchClient(queueName, p, cloudwatchConfig),
+	}
+}
+
+// Limiter is a Source that cannot be consumed by more than a number of pods,
+// like a Kafka topic by more consumers than partitions
+type Limiter interface {
+	MaxConsumers() (int, error)
+}
+
+// ErrNoEmptyReceives is returned by an EmptyReceiver that cannot tell whether
+// its workers are idle at the moment
+var ErrNoEmptyReceives = errors.New("No empty receives reported")
+
+// EmptyReceiver is a Source whose workers report finding it empty, like the
+// empty receives of SQS. Scaling down is only gated on idle workers for such
+// sources.
+type EmptyReceiver interface {
+	// NumEmpty returns the rate workers find the queue empty at, telling
+	// whether they are idle
+	NumEmpty() (float64, error)
+}
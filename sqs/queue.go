@@ -0,0 +1,172 @@
+package sqs
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	queueNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.fifo)?$`)
+	accountRe   = regexp.MustCompile(`^[0-9]{12}$`)
+	// sqs.us-east-1.amazonaws.com, sqs-fips.us-east-1.amazonaws.com and
+	// vpce-0123-abcd.sqs.us-east-1.vpce.amazonaws.com
+	sqsHostRe = regexp.MustCompile(`^(?:[a-z0-9-]+\.)?sqs(?:-fips)?\.([a-z0-9-]+)\.(?:vpce\.)?amazonaws\.com(?:\.cn)?$`)
+	// legacy us-west-2.queue.amazonaws.com and queue.amazonaws.com of us-east-1
+	legacyHostRe = regexp.MustCompile(`^(?:([a-z0-9-]+)\.)?queue\.amazonaws\.com(?:\.cn)?$`)
+)
+
+// Queue identifies an SQS queue. Url is empty until resolved if the queue was
+// given by ARN or name, and Region is empty if the url is of an endpoint that
+// does not tell the region, e.g. ElasticMQ.
+type Queue struct {
+	Url     string
+	Region  string
+	Account string
+	Name    string
+}
+
+type queueUrlGetter interface {
+	GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error)
+}
+
+// ParseQueue parses a queue url, ARN or name. account is the account owning
+// the queue, which must match the url or ARN if set, and defaults to the
+// account of the credentials for names.
+func ParseQueue(queue, account string) (*Queue, error) {
+	if account != "" && !accountRe.MatchString(account) {
+		return nil, errors.Errorf("Invalid AWS account %q, must be 12 digits", account)
+	}
+
+	var q *Queue
+	var err error
+	switch {
+	case queue == "":
+		return nil, errors.New("SQS queue not set")
+	case strings.HasPrefix(queue, "arn:"):
+		q, err = ParseQueueArn(queue)
+	case strings.Contains(queue, "://"):
+		q, err = ParseQueueUrl(queue)
+	default:
+		if !validQueueName(queue) {
+			return nil, errors.Errorf("Invalid SQS queue name %q", queue)
+		}
+		q = &Queue{Account: account, Name: queue}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if account != "" && q.Account != account {
+		return nil, errors.Errorf("SQS queue %s is owned by account %s, not %s", queue, q.Account, account)
+	}
+	return q, nil
+}
+
+// ParseQueueUrl parses urls of the form scheme://host/account/name, inferring
+// the region from AWS hosts
+func ParseQueueUrl(queueUrl string) (*Queue, error) {
+	u, err := url.Parse(queueUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid SQS queue url %q", queueUrl)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("Invalid SQS queue url %q, must be http(s)://host/account/name", queueUrl)
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(path) != 2 || path[0] == "" {
+		return nil, errors.Errorf("Invalid SQS queue url %q, must be http(s)://host/account/name", queueUrl)
+	}
+
+	q := &Queue{Url: queueUrl, Account: path[0], Name: path[1]}
+
+	host := strings.ToLower(u.Hostname())
+	if m := sqsHostRe.FindStringSubmatch(host); m != nil {
+		q.Region = m[1]
+	} else if m := legacyHostRe.FindStringSubmatch(host); m != nil {
+		q.Region = m[1]
+		if q.Region == "" {
+			q.Region = "us-east-1"
+		}
+	}
+
+	// other endpoints need not follow the AWS account format
+	if q.Region != "" && !accountRe.MatchString(q.Account) {
+		return nil, errors.Errorf("Invalid SQS queue url %q, account must be 12 digits", queueUrl)
+	}
+	if !validQueueName(q.Name) {
+		return nil, errors.Errorf("Invalid SQS queue url %q, invalid queue name %q", queueUrl, q.Name)
+	}
+	return q, nil
+}
+
+// ParseQueueArn parses ARNs of the form arn:partition:sqs:region:account:name
+func ParseQueueArn(arn string) (*Queue, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[1] == "" || parts[2] != "sqs" {
+		return nil, errors.Errorf("Invalid SQS queue ARN %q, must be arn:partition:sqs:region:account:name", arn)
+	}
+
+	q := &Queue{Region: parts[3], Account: parts[4], Name: parts[5]}
+	if q.Region == "" {
+		return nil, errors.Errorf("Invalid SQS queue ARN %q, region not set", arn)
+	}
+	if !accountRe.MatchString(q.Account) {
+		return nil, errors.Errorf("Invalid SQS queue ARN %q, account must be 12 digits", arn)
+	}
+	if !validQueueName(q.Name) {
+		return nil, errors.Errorf("Invalid SQS queue ARN %q, invalid queue name %q", arn, q.Name)
+	}
+	return q, nil
+}
+
+// CheckRegion returns the region of the queue, which must match region if
+// both are known
+func (q *Queue) CheckRegion(region string) (string, error) {
+	switch {
+	case q.Region == "" && region == "":
+		return "", errors.Errorf("Region of SQS queue %s cannot be inferred and must be set", q.Name)
+	case q.Region == "":
+		return region, nil
+	case region != "" && region != q.Region:
+		return "", errors.Errorf("SQS queue %s is in region %s, not %s", q.Name, q.Region, region)
+	}
+	return q.Region, nil
+}
+
+// ResolveUrl looks up the url of a queue given by ARN or name
+func (q *Queue) ResolveUrl(p client.ConfigProvider, configs ...*aws.Config) error {
+	if q.Url != "" {
+		return nil
+	}
+	return q.resolveUrl(sqs.New(p, configs...))
+}
+
+func (q *Queue) resolveUrl(client queueUrlGetter) error {
+	input := &sqs.GetQueueUrlInput{QueueName: aws.String(q.Name)}
+	if q.Account != "" {
+		input.QueueOwnerAWSAccountId = aws.String(q.Account)
+	}
+
+	out, err := client.GetQueueUrl(input)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get url of SQS queue %s", q.Name)
+	}
+	if aws.StringValue(out.QueueUrl) == "" {
+		return errors.Errorf("Failed to get url of SQS queue %s", q.Name)
+	}
+
+	q.Url = aws.StringValue(out.QueueUrl)
+	return nil
+}
+
+func validQueueName(name string) bool {
+	return len(name) <= 80 && queueNameRe.MatchString(name)
+}
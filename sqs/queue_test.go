@@ -0,0 +1,156 @@
+package sqs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueueUrl(t *testing.T) {
+	for _, c := range []struct {
+		url    string
+		region string
+	}{
+		{"https://sqs.us-west-2.amazonaws.com/123456789012/orders", "us-west-2"},
+		{"https://sqs-fips.us-east-1.amazonaws.com/123456789012/orders", "us-east-1"},
+		{"https://sqs.cn-north-1.amazonaws.com.cn/123456789012/orders", "cn-north-1"},
+		{"https://us-west-2.queue.amazonaws.com/123456789012/orders", "us-west-2"},
+		{"https://queue.amazonaws.com/123456789012/orders", "us-east-1"},
+		{"https://vpce-0123456789abcdef0-abcdefgh.sqs.eu-west-1.vpce.amazonaws.com/123456789012/orders", "eu-west-1"},
+		{"http://elasticmq:9324/000000000000/orders", ""},
+		{"http://localhost:9324/queue/orders/", ""},
+	} {
+		q, err := ParseQueueUrl(c.url)
+		if assert.Nil(t, err, c.url) {
+			assert.Equal(t, c.region, q.Region, c.url)
+			assert.Equal(t, "orders", q.Name, c.url)
+			assert.Equal(t, c.url, q.Url, c.url)
+		}
+	}
+
+	q, err := ParseQueueUrl("https://sqs.us-west-2.amazonaws.com/123456789012/orders.fifo")
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", q.Account)
+	assert.Equal(t, "orders.fifo", q.Name)
+
+	for _, url := range []string{
+		"sqs.us-west-2.amazonaws.com/123456789012/orders",
+		"ftp://sqs.us-west-2.amazonaws.com/123456789012/orders",
+		"https://sqs.us-west-2.amazonaws.com/orders",
+		"https://sqs.us-west-2.amazonaws.com/123456789012/orders/extra",
+		"https://sqs.us-west-2.amazonaws.com/1234/orders",
+		"https://sqs.us-west-2.amazonaws.com/123456789012/or$ders",
+		"https://sqs.us-west-2.amazonaws.com/123456789012/" + strings.Repeat("a", 81),
+	} {
+		_, err := ParseQueueUrl(url)
+		assert.NotNil(t, err, url)
+	}
+}
+
+func TestParseQueueArn(t *testing.T) {
+	q, err := ParseQueueArn("arn:aws:sqs:eu-central-1:123456789012:orders")
+	assert.Nil(t, err)
+	assert.Equal(t, &Queue{Region: "eu-central-1", Account: "123456789012", Name: "orders"}, q)
+
+	for _, arn := range []string{
+		"arn:aws:sns:eu-central-1:123456789012:orders",
+		"arn:aws:sqs::123456789012:orders",
+		"arn:aws:sqs:eu-central-1:12345:orders",
+		"arn:aws:sqs:eu-central-1:123456789012",
+		"arn:aws:sqs:eu-central-1:123456789012:orders:extra",
+	} {
+		_, err := ParseQueueArn(arn)
+		assert.NotNil(t, err, arn)
+	}
+}
+
+func TestParseQueue(t *testing.T) {
+	q, err := ParseQueue("orders", "123456789012")
+	assert.Nil(t, err)
+	assert.Equal(t, &Queue{Account: "123456789012", Name: "orders"}, q)
+
+	q, err = ParseQueue("orders", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &Queue{Name: "orders"}, q)
+
+	q, err = ParseQueue("arn:aws:sqs:eu-central-1:123456789012:orders", "123456789012")
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-central-1", q.Region)
+
+	q, err = ParseQueue("https://sqs.us-west-2.amazonaws.com/123456789012/orders", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", q.Region)
+
+	_, err = ParseQueue("https://sqs.us-west-2.amazonaws.com/123456789012/orders", "210987654321")
+	assert.NotNil(t, err)
+
+	_, err = ParseQueue("orders", "not-an-account")
+	assert.NotNil(t, err)
+
+	_, err = ParseQueue("", "")
+	assert.NotNil(t, err)
+}
+
+func TestCheckRegion(t *testing.T) {
+	q := &Queue{Region: "us-west-2", Name: "orders"}
+
+	region, err := q.CheckRegion("")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", region)
+
+	region, err = q.CheckRegion("us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", region)
+
+	_, err = q.CheckRegion("us-east-1")
+	assert.NotNil(t, err)
+
+	q = &Queue{Name: "orders"}
+
+	region, err = q.CheckRegion("elasticmq")
+	assert.Nil(t, err)
+	assert.Equal(t, "elasticmq", region)
+
+	_, err = q.CheckRegion("")
+	assert.NotNil(t, err)
+}
+
+func TestResolveUrl(t *testing.T) {
+	m := &MockQueueUrlGetter{Url: "https://sqs.us-west-2.amazonaws.com/123456789012/orders"}
+	q := &Queue{Account: "123456789012", Name: "orders"}
+
+	err := q.resolveUrl(m)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://sqs.us-west-2.amazonaws.com/123456789012/orders", q.Url)
+	assert.Equal(t, "orders", aws.StringValue(m.Input.QueueName))
+	assert.Equal(t, "123456789012", aws.StringValue(m.Input.QueueOwnerAWSAccountId))
+
+	q = &Queue{Name: "orders"}
+	err = q.resolveUrl(m)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Input.QueueOwnerAWSAccountId)
+
+	m.Err = errors.New("AWS.SimpleQueueService.NonExistentQueue")
+	q = &Queue{Name: "missing"}
+	err = q.resolveUrl(m)
+	assert.NotNil(t, err)
+	assert.Equal(t, "", q.Url)
+}
+
+type MockQueueUrlGetter struct {
+	Url   string
+	Err   error
+	Input *sqs.GetQueueUrlInput
+}
+
+func (m *MockQueueUrlGetter) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	m.Input = input
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(m.Url)}, nil
+}
@@ -4,7 +4,7 @@ import (
 	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/sqs"
 
 	"github.com/pkg/errors"
@@ -21,8 +21,11 @@ type SqsClient struct {
 	QueueUrl string
 }
 
-func NewSqsClient(queue string, region string) *SqsClient {
-	svc := sqs.New(session.New(), &aws.Config{Region: aws.String(region)})
+// NewSqsClient creates a client for the queue from a session shared with other
+// clients. The configs set the region and optionally the endpoint, e.g. of
+// ElasticMQ or localstack.
+func NewSqsClient(queue string, p client.ConfigProvider, configs ...*aws.Config) *SqsClient {
+	svc := sqs.New(p, configs...)
 	return &SqsClient{
 		svc,
 		queue,
@@ -30,8 +33,17 @@ func NewSqsClient(queue string, region string) *SqsClient {
 }
 
 func (s *SqsClient) NumMessages() (int, error) {
+	return s.numAttribute("ApproximateNumberOfMessages")
+}
+
+// NumInFlight returns the number of messages received but not yet deleted
+func (s *SqsClient) NumInFlight() (int, error) {
+	return s.numAttribute("ApproximateNumberOfMessagesNotVisible")
+}
+
+func (s *SqsClient) numAttribute(name string) (int, error) {
 	params := &sqs.GetQueueAttributesInput{
-		AttributeNames: []*string{aws.String("ApproximateNumberOfMessages")},
+		AttributeNames: []*string{aws.String(name)},
 		QueueUrl:       aws.String(s.QueueUrl),
 	}
 
@@ -40,9 +52,14 @@ func (s *SqsClient) NumMessages() (int, error) {
 		return 0, errors.Wrap(err, "Failed to get messages in SQS")
 	}
 
-	messages, err := strconv.Atoi(*out.Attributes["ApproximateNumberOfMessages"])
+	value, ok := out.Attributes[name]
+	if !ok || value == nil {
+		return 0, errors.Errorf("Failed to get %s of queue", name)
+	}
+
+	messages, err := strconv.Atoi(*value)
 	if err != nil {
-		return 0, errors.Wrap(err, "Failed to get number of messages in queue")
+		return 0, errors.Wrapf(err, "Failed to get %s of queue", name)
 	}
 
 	return messages, nil
@@ -16,6 +16,22 @@ func TestNumMessages(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNumInFlight(t *testing.T) {
+	s := NewMockSqsClient()
+
+	num, err := s.NumInFlight()
+	assert.Equal(t, 7, num)
+	assert.Nil(t, err)
+}
+
+func TestMissingAttribute(t *testing.T) {
+	s := NewMockSqsClient()
+	s.Client.SetQueueAttributes(&sqs.SetQueueAttributesInput{Attributes: map[string]*string{}})
+
+	_, err := s.NumMessages()
+	assert.NotNil(t, err)
+}
+
 type MockSQS struct {
 	QueueAttributes *sqs.GetQueueAttributesOutput
 }
@@ -35,6 +51,7 @@ func (m *MockSQS) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.S
 func NewMockSqsClient() *SqsClient {
 	Attributes := make(map[string]*string)
 	Attributes["ApproximateNumberOfMessages"] = aws.String("50")
+	Attributes["ApproximateNumberOfMessagesNotVisible"] = aws.String("7")
 
 	return &SqsClient{
 		Client: &MockSQS{